@@ -0,0 +1,353 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+// A podcrawler command
+type command struct {
+	name     string
+	usage    string // Arguments, shown after the name
+	summary  string
+	database bool // Needs an up to date database schema
+	output   bool // Prints results meant to be piped, so log lines go to stderr
+	run      func(args []string) int
+}
+
+// Commands by name. Filled in by init since help lists them
+var commands []command
+
+func init() {
+	commands = []command{
+		{
+			name:     "crawl",
+			usage:    "[-input file|-] [-input-format auto|text|csv|jsonl|opml]",
+			summary:  "Crawl IDs from the configured input file, or another file or stdin. Runs when no command is given",
+			database: true,
+			run:      runCrawl,
+		},
+		{
+			name:     "recrawl",
+			usage:    "[-days n] [-country c] [-genre g] [-limit n]",
+			summary:  "Refresh podcasts saved more than n days ago",
+			database: true,
+			run:      runRecrawl,
+		},
+		{
+			name:     "reprocess",
+			summary:  "Save archived lookup responses again",
+			database: true,
+			run: func([]string) int {
+				return Reprocess(config.AppConfig.SaveTreshold)
+			},
+		},
+		{
+			name:    "migrate",
+			usage:   "[up | down [-steps n] [-force] | status]",
+			summary: "Apply, revert or list database schema migrations. Applies them when no action is given",
+			run:     runMigrate,
+		},
+		{
+			name:     "status",
+			usage:    "[-runs n] [-json]",
+			summary:  "Show the crawl queue, dead letters and recent runs",
+			database: true,
+			output:   true,
+			run:      showStatus,
+		},
+		{
+			name:    "lookup",
+			usage:   "<itunes id|url>",
+			summary: "Look a podcast up on iTunes and print the result as JSON",
+			output:  true,
+			run:     runLookup,
+		},
+		{
+			name:     "history",
+			usage:    "<itunes id>",
+			summary:  "Show how a podcast changed between crawls",
+			database: true,
+			output:   true,
+			run:      showHistory,
+		},
+		{
+			name:     "deadletters",
+			usage:    "[-category c] [-limit n]",
+			summary:  "List IDs that failed to crawl",
+			database: true,
+			output:   true,
+			run:      listDeadLetters,
+		},
+		{
+			name:     "requeue",
+			usage:    "[-category c] [id...]",
+			summary:  "Move dead lettered IDs back into the crawl queue",
+			database: true,
+			run:      requeueDeadLetters,
+		},
+		{
+			name:     "export",
+			usage:    "[-format jsonl|csv] [-output file] [-country c] [-genre g] [-limit n]",
+			summary:  "Write saved podcasts to a file or stdout",
+			database: true,
+			output:   true,
+			run:      runExport,
+		},
+		{
+			name:    "config",
+			usage:   "validate [-connect]",
+			summary: "Check the resolved config, and optionally the database connection",
+			run:     runConfig,
+		},
+		{
+			name:    "help",
+			summary: "Show this help",
+			run: func([]string) int {
+				printUsage(os.Stdout)
+				return ExitOK
+			},
+		},
+	}
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintln(out, "Usage:")
+	fmt.Fprintln(out, "  podcrawler [flags] [command] [arguments]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Settings are read from the embedded config, then a -config file, then PODCRAWLER_*")
+	fmt.Fprintln(out, "environment variables, then flags named after them. Run `podcrawler -h` to list them.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %s\n", strings.TrimSpace("podcrawler "+c.name+" "+c.usage))
+		fmt.Fprintf(out, "      %s\n", c.summary)
+	}
+}
+
+// Runs a named command with its arguments and returns the exit code
+func RunCommand(name string, args []string) int {
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+
+		if c.output {
+			logger.ConsoleToStderr()
+		}
+		if c.database {
+			if err := checkSchema(); err != nil {
+				return ExitError
+			}
+		}
+		return c.run(args)
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command `%s`\n\n", name)
+	printUsage(os.Stderr)
+	return ExitError
+}
+
+func runCrawl(args []string) int {
+	flags := flag.NewFlagSet("crawl", flag.ContinueOnError)
+	input := flags.String("input", config.AppConfig.PodcastListFile, "File of IDs or podcast urls to crawl, or - for stdin")
+	format := flags.String("input-format", config.AppConfig.InputFormat, "Format of the input: auto, text, csv, jsonl or opml")
+	if err := flags.Parse(args); err != nil {
+		return ExitError
+	}
+	if flags.NArg() > 0 {
+		printUsage(os.Stderr)
+		return ExitError
+	}
+
+	logger.PrintHeading("Podcast Feed Fetcher")
+	return Start(context.Background(), config.AppConfig.SaveTreshold, podcast.InputOptions{
+		Path:    *input,
+		Format:  *format,
+		IDField: config.AppConfig.InputIDField,
+	})
+}
+
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		printUsage(os.Stderr)
+		return ExitError
+	}
+
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	connect := flags.Bool("connect", false, "Also check that the database can be reached")
+	if err := flags.Parse(args[1:]); err != nil {
+		return ExitError
+	}
+
+	// Invalid settings stop podcrawler before any command runs, so reaching
+	// this point means the config resolved
+	logger.Success.Println("Config is valid")
+	if !*connect {
+		return ExitOK
+	}
+
+	store, err := database.GetStore()
+	if err != nil {
+		logger.Error.Printf("Unable to open the database: %v\n", err)
+		return ExitError
+	}
+	logger.Success.Printf("Connected to the %s database\n", store.Backend())
+	return ExitOK
+}
+
+func runRecrawl(args []string) int {
+	flags := flag.NewFlagSet("recrawl", flag.ContinueOnError)
+	days := flags.Int("days", config.AppConfig.RecrawlAfterDays, "Only refresh podcasts last updated more than this many days ago (0 for any age)")
+	country := flags.String("country", "", "Only refresh podcasts from this country")
+	genre := flags.String("genre", "", "Only refresh podcasts in this genre")
+	limit := flags.Int("limit", 0, "Maximum number of podcasts to refresh (0 for all)")
+	if err := flags.Parse(args); err != nil {
+		return ExitError
+	}
+
+	return Recrawl(context.Background(), config.AppConfig.SaveTreshold, service.StaleFilter{
+		OlderThan: time.Duration(*days) * 24 * time.Hour,
+		Country:   *country,
+		Genre:     *genre,
+		Limit:     *limit,
+	})
+}
+
+func showHistory(args []string) int {
+	if len(args) != 1 {
+		printUsage(os.Stderr)
+		return ExitError
+	}
+
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		logger.Error.Printf("Invalid iTunes ID `%s`\n", args[0])
+		return ExitError
+	}
+
+	db, err := database.GetInstance()
+	if err != nil {
+		logger.Error.Printf("Unable to get database instance: %v\n", err)
+		return ExitError
+	}
+
+	revisions, err := service.PodcastHistory(db, id)
+	if err != nil {
+		logger.Error.Printf("Failed to get history of podcast %d: %v\n", id, err)
+		return ExitError
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGED AT\tRUN\tFIELD\tOLD\tNEW")
+	for _, r := range revisions {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\n",
+			r.ChangedAt.Format(time.RFC3339),
+			r.RunID,
+			r.Field,
+			revisionText(r.OldValue),
+			revisionText(r.NewValue),
+		)
+	}
+	w.Flush()
+
+	fmt.Printf("%d changes\n", len(revisions))
+	return ExitOK
+}
+
+func revisionText(value *string) string {
+	if value == nil {
+		return "<null>"
+	}
+	return *value
+}
+
+func listDeadLetters(args []string) int {
+	flags := flag.NewFlagSet("deadletters", flag.ContinueOnError)
+	category := flags.String("category", "", "Only list failures of this category")
+	limit := flags.Int("limit", 100, "Maximum number of entries to list (0 for all)")
+	if err := flags.Parse(args); err != nil {
+		return ExitError
+	}
+
+	db, err := database.GetInstance()
+	if err != nil {
+		logger.Error.Printf("Unable to get database instance: %v\n", err)
+		return ExitError
+	}
+
+	deadLetters, err := service.ListDeadLetters(db, models.FailureCategory(*category), *limit)
+	if err != nil {
+		logger.Error.Printf("Failed to list dead letters: %v\n", err)
+		return ExitError
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ITUNES ID\tCATEGORY\tSTATUS\tATTEMPTS\tFIRST FAILED\tLAST FAILED")
+	for _, d := range deadLetters {
+		fmt.Fprintf(
+			w,
+			"%d\t%s\t%d\t%d\t%s\t%s\n",
+			d.ItunesID,
+			d.Category,
+			d.HttpStatus,
+			d.Attempts,
+			d.FirstFailedAt.Format(time.RFC3339),
+			d.LastFailedAt.Format(time.RFC3339),
+		)
+	}
+	w.Flush()
+
+	fmt.Printf("%d entries\n", len(deadLetters))
+	return ExitOK
+}
+
+func requeueDeadLetters(args []string) int {
+	flags := flag.NewFlagSet("requeue", flag.ContinueOnError)
+	category := flags.String("category", "", "Only requeue failures of this category")
+	if err := flags.Parse(args); err != nil {
+		return ExitError
+	}
+
+	ids := make([]uint64, 0, flags.NArg())
+	for _, arg := range flags.Args() {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			logger.Error.Printf("Invalid iTunes ID `%s`\n", arg)
+			return ExitError
+		}
+		ids = append(ids, id)
+	}
+
+	db, err := database.GetInstance()
+	if err != nil {
+		logger.Error.Printf("Unable to get database instance: %v\n", err)
+		return ExitError
+	}
+
+	requeued, err := service.RequeueDeadLetters(db, ids, models.FailureCategory(*category))
+	if err != nil {
+		logger.Error.Printf("Failed to requeue dead letters: %v\n", err)
+		return ExitError
+	}
+
+	logger.Success.Printf("Requeued %d dead lettered IDs. They will be crawled on the next run\n", requeued)
+	return ExitOK
+}
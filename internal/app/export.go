@@ -0,0 +1,229 @@
+package app
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+)
+
+// Podcasts read from the database per batch while exporting
+const exportBatchSize = 500
+
+// A saved podcast as it is exported
+type exportedPodcast struct {
+	ItunesID              *uint32   `json:"itunes_id"`
+	Title                 string    `json:"title"`
+	ArtistName            *string   `json:"artist_name"`
+	FeedUrl               *string   `json:"feed_url"`
+	Country               *string   `json:"country"`
+	PrimaryGenre          *string   `json:"primary_genre"`
+	Genres                []string  `json:"genres"`
+	EpisodeCount          *uint32   `json:"episode_count"`
+	ContentAdvisoryRating *string   `json:"content_advisory_rating"`
+	ReleaseDate           *string   `json:"release_date"`
+	ItunesViewUrl         *string   `json:"itunes_view_url"`
+	ArtworkUrl600         *string   `json:"artwork_url_600"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+var exportColumns = []string{
+	"itunes_id",
+	"title",
+	"artist_name",
+	"feed_url",
+	"country",
+	"primary_genre",
+	"genres",
+	"episode_count",
+	"content_advisory_rating",
+	"release_date",
+	"itunes_view_url",
+	"artwork_url_600",
+	"updated_at",
+}
+
+func exportPodcast(p models.Podcast) exportedPodcast {
+	genres := make([]string, 0, len(p.PodcastGenres))
+	for _, pg := range p.PodcastGenres {
+		if pg.Genre.Name != nil {
+			genres = append(genres, *pg.Genre.Name)
+		}
+	}
+
+	var primaryGenre *string
+	if p.PrimaryGenre != nil {
+		primaryGenre = p.PrimaryGenre.Name
+	}
+
+	updatedAt := p.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = p.CreatedAt
+	}
+
+	return exportedPodcast{
+		ItunesID:              p.ItunesID,
+		Title:                 p.Title,
+		ArtistName:            p.ArtistName,
+		FeedUrl:               p.FeedUrl,
+		Country:               p.Country,
+		PrimaryGenre:          primaryGenre,
+		Genres:                genres,
+		EpisodeCount:          p.EpisodeCount,
+		ContentAdvisoryRating: p.ContentAdvisoryRating,
+		ReleaseDate:           p.ReleaseDate,
+		ItunesViewUrl:         p.ItunesViewUrl,
+		ArtworkUrl600:         p.ItunesArtworkUrl600,
+		UpdatedAt:             updatedAt,
+	}
+}
+
+// Fields of the podcast in the order of exportColumns. Genres are joined
+// with semicolons
+func (e exportedPodcast) record() []string {
+	return []string{
+		optionalNumber(e.ItunesID),
+		e.Title,
+		optionalText(e.ArtistName),
+		optionalText(e.FeedUrl),
+		optionalText(e.Country),
+		optionalText(e.PrimaryGenre),
+		strings.Join(e.Genres, ";"),
+		optionalNumber(e.EpisodeCount),
+		optionalText(e.ContentAdvisoryRating),
+		optionalText(e.ReleaseDate),
+		optionalText(e.ItunesViewUrl),
+		optionalText(e.ArtworkUrl600),
+		e.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+func optionalText(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func optionalNumber(value *uint32) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*value), 10)
+}
+
+// Writes exported podcasts in one format
+type exportWriter interface {
+	Write(p exportedPodcast) error
+	Flush() error
+}
+
+type jsonlExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlExportWriter) Write(p exportedPodcast) error {
+	return w.encoder.Encode(p)
+}
+
+func (w *jsonlExportWriter) Flush() error {
+	return nil
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvExportWriter) Write(p exportedPodcast) error {
+	return w.writer.Write(p.record())
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func newExportWriter(format string, out io.Writer) (exportWriter, error) {
+	switch format {
+	case "jsonl":
+		return &jsonlExportWriter{encoder: json.NewEncoder(out)}, nil
+	case "csv":
+		w := csv.NewWriter(out)
+		return &csvExportWriter{writer: w}, w.Write(exportColumns)
+	default:
+		return nil, fmt.Errorf("unknown export format `%s`", format)
+	}
+}
+
+// Streams saved podcasts to a file or stdout, a batch at a time
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "jsonl", "Output format, jsonl or csv")
+	output := flags.String("output", "-", "File to write to, or - for stdout")
+	country := flags.String("country", "", "Only export podcasts from this country")
+	genre := flags.String("genre", "", "Only export podcasts in this genre")
+	limit := flags.Int("limit", 0, "Maximum number of podcasts to export (0 for all)")
+	if err := flags.Parse(args); err != nil {
+		return ExitError
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			logger.Error.Printf("Unable to create export file: %v\n", err)
+			return ExitError
+		}
+		defer file.Close()
+		out = file
+	}
+	buffered := bufio.NewWriter(out)
+
+	writer, err := newExportWriter(*format, buffered)
+	if err != nil {
+		logger.Error.Println(err)
+		return ExitError
+	}
+
+	db, err := database.GetInstance()
+	if err != nil {
+		logger.Error.Printf("Unable to get database instance: %v\n", err)
+		return ExitError
+	}
+
+	var exported int
+	filter := service.StaleFilter{Country: *country, Genre: *genre, Limit: *limit}
+	err = service.EachPodcast(db, filter, exportBatchSize, func(podcasts []models.Podcast) error {
+		for _, p := range podcasts {
+			if err := writer.Write(exportPodcast(p)); err != nil {
+				return err
+			}
+			exported++
+		}
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		logger.Error.Printf("Export failed after %d podcasts: %v\n", exported, err)
+		return ExitError
+	}
+
+	logger.Success.Printf("Exported %d podcasts\n", exported)
+	return ExitOK
+}
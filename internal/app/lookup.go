@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+// Looks a single podcast up on iTunes and prints the result as it was
+// received, without saving it
+func runLookup(args []string) int {
+	if len(args) != 1 {
+		printUsage(os.Stderr)
+		return ExitError
+	}
+
+	id, err := podcast.ParseID(args[0])
+	if err != nil {
+		logger.Error.Println(err)
+		return ExitError
+	}
+
+	timeout := time.Duration(config.AppConfig.RequestTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	response, err := podcast.Lookup(ctx, nil, config.AppConfig.UserAgent, id)
+	if err != nil {
+		logger.Error.Printf("Failed to look up podcast %d: %v\n", id, err)
+		return ExitError
+	}
+	if len(response.Results) == 0 {
+		logger.Error.Printf("iTunes has no podcast with ID %d\n", id)
+		return ExitError
+	}
+
+	out, err := json.MarshalIndent(response.Results[0].Raw, "", "  ")
+	if err != nil {
+		logger.Error.Printf("Failed to format the lookup result: %v\n", err)
+		return ExitError
+	}
+	fmt.Println(string(out))
+
+	return ExitOK
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/migrations"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+)
+
+// Refuses to go on against a schema that isn't the one this build migrates
+// to, so commands never write to tables they don't expect
+func checkSchema() error {
+	store, err := database.GetStore()
+	if err != nil {
+		logger.Error.Printf("Unable to open the database: %v\n", err)
+		return err
+	}
+
+	err = store.CheckSchema()
+	if errors.Is(err, migrations.ErrOutdated) {
+		logger.Error.Printf("%v. Run `podcrawler migrate up` to apply them\n", err)
+	} else if err != nil {
+		logger.Error.Println(err)
+	}
+
+	return err
+}
+
+func runMigrate(args []string) int {
+	action := "up"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "up":
+		return migrateUp(args)
+	case "down":
+		return migrateDown(args)
+	case "status":
+		return showMigrations(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown migrate action `%s`\n\n", action)
+		printUsage(os.Stderr)
+		return ExitError
+	}
+}
+
+func migrateUp(args []string) int {
+	if len(args) > 0 {
+		printUsage(os.Stderr)
+		return ExitError
+	}
+
+	store, err := database.GetStore()
+	if err != nil {
+		logger.Error.Printf("Unable to open the database: %v\n", err)
+		return ExitError
+	}
+
+	logger.Info.Println("Database migrations started")
+	applied, err := store.Migrate()
+	for _, migration := range applied {
+		logger.Info.Printf("Applied migration %s\n", migration)
+	}
+	if err != nil {
+		logger.Error.Printf("Database migrations failed: %v\n", err)
+		return ExitError
+	}
+
+	logger.Success.Printf("Database migrations successful. Applied %d migrations\n", len(applied))
+	return ExitOK
+}
+
+func migrateDown(args []string) int {
+	flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+	steps := flags.Int("steps", 1, "Number of migrations to revert, latest first")
+	force := flags.Bool("force", false, "Revert migrations that drop tables holding crawled data")
+	if err := flags.Parse(args); err != nil {
+		return ExitError
+	}
+
+	store, err := database.GetStore()
+	if err != nil {
+		logger.Error.Printf("Unable to open the database: %v\n", err)
+		return ExitError
+	}
+
+	reverted, err := store.Rollback(*steps, *force)
+	for _, migration := range reverted {
+		logger.Info.Printf("Reverted migration %s\n", migration)
+	}
+	if errors.Is(err, migrations.ErrDestructive) {
+		logger.Error.Printf("%v. Nothing was reverted. Back up the database and pass -force to go ahead\n", err)
+		return ExitError
+	}
+	if err != nil {
+		logger.Error.Printf("Reverting migrations failed: %v\n", err)
+		return ExitError
+	}
+
+	logger.Success.Printf("Reverted %d migrations\n", len(reverted))
+	return ExitOK
+}
+
+func showMigrations(args []string) int {
+	if len(args) > 0 {
+		printUsage(os.Stderr)
+		return ExitError
+	}
+
+	store, err := database.GetStore()
+	if err != nil {
+		logger.Error.Printf("Unable to open the database: %v\n", err)
+		return ExitError
+	}
+
+	states, err := store.MigrationStatus()
+	if err != nil {
+		logger.Error.Printf("Failed to list migrations: %v\n", err)
+		return ExitError
+	}
+
+	var pending int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tAPPLIED AT")
+	for _, state := range states {
+		appliedAt := "pending"
+		switch {
+		case state.Unknown:
+			appliedAt = state.AppliedAt.Format(time.RFC3339) + " (unknown to this build)"
+		case state.AppliedAt != nil:
+			appliedAt = state.AppliedAt.Format(time.RFC3339)
+		default:
+			pending++
+		}
+		fmt.Fprintf(w, "%s\t%s\n", state.Migration, appliedAt)
+	}
+	w.Flush()
+
+	fmt.Printf("%d pending migrations\n", pending)
+	return ExitOK
+}
@@ -1,174 +1,673 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/archive"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/ratelimit"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/structures"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+	"gorm.io/gorm"
 	// "gorm.io/gorm/clause"
 )
 
+// Process exit codes returned by Start
+const (
+	ExitOK          = 0   // Every ID was processed successfully
+	ExitError       = 1   // The crawl could not start or finish cleanly
+	ExitIncomplete  = 2   // The crawl finished but some IDs failed
+	ExitInterrupted = 130 // The crawl was stopped by a signal or a Stop command
+)
+
+// Input IDs added to the crawl queue at a time, so inputs are never held in
+// memory whole
+const inputChunkSize = 10000
+
+// Pending crawl queue items handed to the fetcher at a time
+const queuePageSize = 10000
+
 type orchestrator struct {
+	run          models.CrawlRun
 	saveTreshold int
-	payloads     structures.Pool[podcast.ItunesResult]
 	failedIds    structures.Pool[uint64]
 	fetcher      *podcast.Fetcher
+	archive      *archive.Archive // Stores raw lookup responses. nil when archiving is disabled
+	signals      chan os.Signal
+	cancelRun    context.CancelFunc // Aborts in-flight requests
+	queueAfter   uint64             // Highest crawl queue ID handed to the fetcher so far
+
+	// Pipeline stages between the fetcher and the database
+	parsed         chan []podcast.ItunesResult
+	converted      chan []models.Podcast
+	stopParsing    chan struct{}
+	flush          chan struct{} // Asks the persist stage to save its buffer early
+	persisted      chan struct{} // Closed once the persist stage has saved everything
+	parseWorkers   sync.WaitGroup
+	convertWorkers sync.WaitGroup
+	handled        atomic.Int64 // Responses the parse stage is done with
+	queued         atomic.Int64 // Batches in the convert and persist stages, counted until saved
+
+	savedCount    atomic.Int64
+	failedCount   atomic.Int64
+	requeuedCount atomic.Int64
+
+	// Lookup attempts per ID, counted when the ID's batch is fired
+	attempts      map[uint64]uint32
+	attemptsMutex sync.Mutex
+	maxAttempts   uint32
+	backoffBase   time.Duration
+	backoffMax    time.Duration
+
+	// Missing IDs being bisected, mapped to the size of the lookup they
+	// were missing from
+	sizer          *podcast.BatchSizer
+	bisecting      map[uint64]int
+	bisectingMutex sync.Mutex
 }
 
-func newOrchestrator(saveTreshold int) orchestrator {
-	o := orchestrator{
+func newOrchestrator(saveTreshold int) *orchestrator {
+	o := &orchestrator{
 		saveTreshold: saveTreshold,
-		payloads:     structures.CreatePool([]podcast.ItunesResult{}),
 		failedIds:    structures.CreatePool([]uint64{}),
+		signals:      make(chan os.Signal, 1),
+
+		attempts:    make(map[uint64]uint32),
+		maxAttempts: uint32(config.AppConfig.MaxFetchAttempts),
+		backoffBase: time.Duration(config.AppConfig.RetryBackoffSeconds) * time.Second,
+		backoffMax:  time.Duration(config.AppConfig.RetryBackoffMaxSeconds) * time.Second,
+
+		sizer: podcast.NewBatchSizer(
+			config.AppConfig.SingleFetchIDsCount,
+			config.AppConfig.FetchIDsCountWindow,
+			config.AppConfig.AdaptiveFetchIDsCount,
+		),
+		bisecting: make(map[uint64]int),
 	}
-	logger.Info.Printf("Orchestrator created with a save treshold of %d results\n", saveTreshold)
+	logger.Info.Printf(
+		"Orchestrator created with a save treshold of %d results, saved to %s with the %s backend\n",
+		saveTreshold,
+		config.AppConfig.Database.Backend,
+		config.AppConfig.PersistenceBackend,
+	)
+	logger.Info.Printf(
+		"IDs are retried up to %d times with backoff between %v and %v\n",
+		o.maxAttempts,
+		o.backoffBase,
+		o.backoffMax,
+	)
 
 	return o
 }
 
-func Start(saveTreshold int) {
-	ids, err := podcast.GetIDs()
+// Crawls the IDs read from input, along with any left in the crawl queue by
+// earlier runs. Returns the exit code
+func Start(ctx context.Context, saveTreshold int, input podcast.InputOptions) int {
+	source, err := podcast.OpenInput(input)
 	if err != nil {
-		logger.Error.Fatalf("Failed to get podcast IDs from input: %v", err)
+		logger.Error.Printf("Failed to open input `%s`: %v\n", input.Path, err)
+		return ExitError
 	}
+	defer source.Close()
 
-	ids, err = filterCrawled(ids)
+	pending, err := loadQueue(source)
 	if err != nil {
-		logger.Error.Fatalf("Failed to filter out crawled podcasts: %v", err)
+		logger.Error.Printf("Failed to load crawl queue: %v\n", err)
+		return ExitError
 	}
 
-	if len(ids) == 0 {
+	if pending == 0 {
 		logger.Success.Println("All IDs have already been processed. No further action is needed")
-		os.Exit(0)
+		return ExitOK
+	}
+
+	return crawl(ctx, saveTreshold, models.CrawlRun{Mode: models.RunCrawl, InputFile: &input.Path}, pending, nil)
+}
+
+// Looks saved podcasts matching filter up again to refresh their metadata.
+// Fields that changed are logged as the refreshed podcasts are saved
+func Recrawl(ctx context.Context, saveTreshold int, filter service.StaleFilter) int {
+	db, err := database.GetInstance()
+	if err != nil {
+		logger.Error.Printf("Unable to get database instance: %v\n", err)
+		return ExitError
 	}
 
+	ids, err := service.StalePodcastIDs(db, filter)
+	if err != nil {
+		logger.Error.Printf("Failed to find podcasts to recrawl: %v\n", err)
+		return ExitError
+	}
+	if len(ids) == 0 {
+		logger.Success.Println("No podcasts need refreshing")
+		return ExitOK
+	}
+
+	if err := service.Requeue(db, ids); err != nil {
+		logger.Error.Printf("Failed to queue podcasts for recrawling: %v\n", err)
+		return ExitError
+	}
+	logger.Info.Printf("Recrawling %d podcasts\n", len(ids))
+
+	return crawl(ctx, saveTreshold, models.CrawlRun{Mode: models.RunRecrawl}, int64(len(ids)), ids)
+}
+
+// Looks up ids, or the pending crawl queue a page at a time when ids is nil,
+// until every ID is saved or dead lettered, or the crawl is interrupted. The
+// crawl of total IDs is recorded as run. Returns the exit code
+func crawl(ctx context.Context, saveTreshold int, run models.CrawlRun, total int64, ids []uint64) int {
 	o := newOrchestrator(saveTreshold)
-	o.fetcher = podcast.NewFetcher(
-		ids,
-		config.AppConfig.ConcurrentFetchBatchSize,
-		config.AppConfig.SingleFetchIDsCount,
-	)
+	if err := o.startRun(run, total); err != nil {
+		logger.Error.Printf("Failed to record crawl run: %v\n", err)
+		return ExitError
+	}
+
+	if config.AppConfig.ArchiveResponses {
+		a, err := archive.Open(config.AppConfig.ArchiveDir)
+		if err != nil {
+			logger.Error.Printf("Failed to open response archive: %v\n", err)
+			o.finishRun(ExitError, 0)
+			return ExitError
+		}
+		o.archive = a
+		logger.Info.Printf("Archiving lookup responses to `%s`\n", config.AppConfig.ArchiveDir)
+	}
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	o.cancelRun = cancelRun
+
+	options := o.fetcherOptions()
+	if ids == nil {
+		options.Refill = o.nextQueuePage
+	}
+	o.fetcher = podcast.NewFetcher(runCtx, ids, options)
+	o.fetcher.OnBatch = o.onBatch
+
+	signal.Notify(o.signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(o.signals)
 
+	o.startPipeline(
+		config.AppConfig.ParseWorkers,
+		config.AppConfig.ConvertWorkers,
+		config.AppConfig.StageBufferSize,
+	)
 	o.fetcher.Start()
 
 	for {
 		select {
-		case r := <-o.fetcher.ResponseChannel:
-			o.onFetchResponse(r)
+		case <-o.fetcher.DrainedChannel:
+			if o.isIdle() {
+				logger.Success.Println("Done crawling IDs")
+				return o.shutdown(false)
+			}
+			o.flushBuffered()
+		case <-o.fetcher.StoppedChannel:
+			logger.Warn.Println("Fetcher stopped before all IDs were processed")
+			return o.shutdown(true)
+		case s := <-o.signals:
+			logger.Warn.Printf("Received %v signal\n", s)
+			return o.shutdown(true)
+		case <-ctx.Done():
+			logger.Warn.Printf("Crawl cancelled: %v\n", ctx.Err())
+			return o.shutdown(true)
 		}
 	}
 }
 
-func filterCrawled(ids []uint64) ([]uint64, error) {
-	db, err := database.GetInstance()
+func (o *orchestrator) fetcherOptions() podcast.FetcherOptions {
+	requestTimeout := time.Duration(config.AppConfig.RequestTimeoutSeconds) * time.Second
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = config.AppConfig.MaxConcurrentFetches
+	transport.ResponseHeaderTimeout = requestTimeout
+
+	return podcast.FetcherOptions{
+		Client:         &http.Client{Transport: transport},
+		UserAgent:      config.AppConfig.UserAgent,
+		RequestTimeout: requestTimeout,
+
+		Concurrency: podcast.NewConcurrencyController(podcast.ConcurrencyOptions{
+			Initial:       config.AppConfig.ConcurrentFetchBatchSize,
+			Min:           config.AppConfig.MinConcurrentFetches,
+			Max:           config.AppConfig.MaxConcurrentFetches,
+			Increase:      1,
+			Decrease:      0.5,
+			TargetLatency: time.Duration(config.AppConfig.TargetLatencyMs) * time.Millisecond,
+			MaxErrorRate:  float64(config.AppConfig.MaxErrorRatePercent) / 100,
+		}),
+		DecisionInterval: time.Duration(config.AppConfig.ConcurrencyIntervalSeconds) * time.Second,
+		Sizer:            o.sizer,
+		Limiter: ratelimit.NewTokenBucket(
+			config.AppConfig.RequestsPerMinute,
+			config.AppConfig.RequestBurst,
+			time.Duration(config.AppConfig.ThrottleCooldownSeconds)*time.Second,
+		),
+	}
+}
+
+// Stops the fetcher, waits for in-flight requests to make it through the
+// pipeline, then persists failed IDs. Returns the exit code
+func (o *orchestrator) shutdown(interrupted bool) int {
+	logger.Info.Println("Shutting down. Waiting for in-flight requests to complete...")
+
+	o.fetcher.Stop()
+	aborted := false
+	for stopped := false; !stopped; {
+		select {
+		case <-o.fetcher.StoppedChannel:
+			stopped = true
+		case s := <-o.signals:
+			if aborted {
+				logger.Error.Printf("Received %v signal again. Exitting without saving\n", s)
+				os.Exit(ExitInterrupted)
+			}
+			logger.Warn.Printf("Received %v signal during shutdown. Aborting in-flight requests\n", s)
+			o.cancelRun()
+			aborted = true
+		}
+	}
+
+	logger.Info.Println("Waiting for the pipeline to drain...")
+	o.drainPipeline()
+
+	failedCount := o.failedCount.Load()
+	if failedCount > 0 {
+		logger.Warn.Printf("%d IDs failed during this run. See `podcrawler deadletters list`\n", failedCount)
+	}
+
+	code := ExitOK
+	if interrupted {
+		code = ExitInterrupted
+	} else if failedCount > 0 {
+		code = ExitIncomplete
+	}
+
+	unrecordedCount := o.failedIds.Length()
+	if unrecordedCount > 0 {
+		failedListFile := config.AppConfig.FailedListFile
+		err := podcast.WriteIDs(failedListFile, o.failedIds.Take(unrecordedCount))
+		if err != nil {
+			logger.Error.Printf("Failed to persist %d failed IDs: %v\n", unrecordedCount, err)
+			code = ExitError
+		} else {
+			logger.Warn.Printf("%d failed IDs that couldn't be dead lettered written to `%s`\n", unrecordedCount, failedListFile)
+		}
+	}
+
+	sizerStats := o.sizer.Stats()
+	logger.Info.Printf(
+		"Lookup size: %d IDs (limit: %d, converged: %v, drop ratio: %.2f%%)\n",
+		sizerStats.Size,
+		sizerStats.Limit,
+		sizerStats.Converged,
+		sizerStats.DropRatio*100,
+	)
+
+	o.finishRun(code, unrecordedCount)
+
+	logger.Info.Println("Shutdown complete")
+	return code
+}
+
+// Streams input IDs into the persistent crawl queue, recovers IDs left in
+// flight by a previous run and returns the number of IDs still pending
+func loadQueue(input podcast.InputSource) (int64, error) {
+	store, err := database.GetStore()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	db := store.DB()
+
+	logger.Info.Printf("Adding %s input IDs to the crawl queue\n", input.Format())
+	var read, enqueued int64
+	chunk := make([]uint64, 0, inputChunkSize)
+	flush := func() error {
+		n, err := service.EnqueueIDs(db, chunk)
+		enqueued += n
+		chunk = chunk[:0]
+		return err
+	}
+	for {
+		id, err := input.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("reading input failed after %d IDs: %w", read, err)
+		}
 
-	logger.Info.Println("Looking up known ids from database")
-	var crawledIds []uint64
-	err = db.Model(&models.Podcast{}).Pluck("ItunesID", &crawledIds).Error
+		chunk = append(chunk, id)
+		read++
+		if len(chunk) == inputChunkSize {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	logger.Info.Printf("Read %d input IDs, %d of them new to the crawl queue\n", read, enqueued)
+	if skipped := input.Skipped(); skipped > 0 {
+		logger.Warn.Printf("Skipped %d input entries without an iTunes ID\n", skipped)
+	}
+
+	recovered, err := service.ResetInFlight(db)
+	if err != nil {
+		return 0, err
+	}
+	if recovered > 0 {
+		logger.Warn.Printf("Recovered %d IDs left in flight by a previous run\n", recovered)
+	}
+
+	crawled, err := store.MarkCrawled()
+	if err != nil {
+		return 0, err
+	}
+	logger.Info.Printf("Marked %d queued IDs with saved podcasts as done\n", crawled)
+
+	pending, err := service.CountPending(db)
+	if err != nil {
+		return 0, err
+	}
+	logger.Info.Printf("%d pending IDs found in the crawl queue\n", pending)
+
+	return pending, nil
+}
+
+// Reads the next page of pending crawl queue items for the fetcher. Items
+// still backing off from a previous run are delayed rather than returned,
+// so pages are read until one has ready IDs or the queue runs out
+func (o *orchestrator) nextQueuePage() ([]uint64, error) {
+	db, err := database.GetInstance()
 	if err != nil {
 		return nil, err
 	}
-	logger.Info.Printf("Lookup successful. Found %d entries\n", len(crawledIds))
 
-	logger.Info.Println("Comparing slices and extracting unprocessed IDs")
-	unprocessedIds := utils.LeftDiff(ids, crawledIds)
-	logger.Info.Printf("Comparison done. %d unprocessed IDs found\n", len(unprocessedIds))
+	for {
+		items, err := service.PendingPage(db, o.queueAfter, queuePageSize)
+		if err != nil || len(items) == 0 {
+			return nil, err
+		}
+		o.queueAfter = items[len(items)-1].ItunesID
 
-	return unprocessedIds, nil
+		if ready := o.resumeItems(items); len(ready) > 0 {
+			return ready, nil
+		}
+	}
 }
 
-func (o *orchestrator) Save() {
-	db, _ := database.GetInstance()
-	tx := db.Begin()
+// Resumes retries in progress: keeps the attempt counts of items and delays
+// the ones still backing off. Returns the IDs of items ready to be looked up
+func (o *orchestrator) resumeItems(items []models.CrawlQueueItem) []uint64 {
+	now := time.Now()
+	ready := make([]uint64, 0, len(items))
+	delayed := 0
+
+	o.attemptsMutex.Lock()
+	for _, item := range items {
+		if item.Attempts > 0 {
+			o.attempts[item.ItunesID] = item.Attempts
+		}
+	}
+	o.attemptsMutex.Unlock()
 
-	resultsCount := o.payloads.Length()
-	logger.Info.Printf("Converting %d results to models\n", resultsCount)
-	results := o.payloads.Take(resultsCount)
-	podcasts := make([]models.Podcast, 0, resultsCount)
-	for _, result := range results {
-		p, err := service.PodcastFromItunesResult(result)
-		if err != nil {
-			tx.Rollback()
-			logger.Error.Fatalf("Save failed: Unable to convert results into database models: %v\n", err)
+	for _, item := range items {
+		if item.NextAttemptAt != nil && item.NextAttemptAt.After(now) {
+			o.fetcher.Delay(*item.NextAttemptAt, item.ItunesID)
+			delayed++
+			continue
+		}
+		ready = append(ready, item.ItunesID)
+	}
+	if delayed > 0 {
+		logger.Info.Printf("%d IDs are waiting out their retry backoff from a previous run\n", delayed)
+	}
+
+	return ready
+}
+
+// Saves podcasts to the database. The persist stage is blocked while this
+// runs, which holds back the rest of the pipeline and the fetcher
+func (o *orchestrator) Save(podcasts []models.Podcast) {
+	store, err := database.GetStore()
+	if err != nil {
+		logger.Error.Printf("Failed to save %d results: %v\n", len(podcasts), err)
+		o.Requeue(itunesIds(podcasts), models.FailurePersistError, 0)
+		return
+	}
+
+	for i := range podcasts {
+		podcasts[i].CrawlRunID = &o.run.ID
+	}
+
+	result, err := store.SavePodcasts(o.run.ID, podcasts)
+
+	if len(result.Saved) > 0 {
+		savedIds := itunesIds(result.Saved)
+		o.updateQueue(savedIds, service.MarkDone)
+		o.forgetAttempts(savedIds)
+		o.savedCount.Add(result.Written)
+		o.recordProgress()
+
+		for _, change := range result.Changes {
+			logger.Info.Printf("Podcast %d changed: %s\n", change.ItunesID, change)
 		}
-		podcasts = append(podcasts, *p)
+		if len(result.Changes) > 0 {
+			logger.Info.Printf("%d saved podcasts changed since they were last crawled\n", len(result.Changes))
+		}
+		logger.Success.Printf("Successfully saved %d/%d results to database\n", result.Written, len(podcasts))
 	}
 
-	tx.CreateInBatches(podcasts, 1000)
-	if tx.Error != nil {
+	if len(result.Rejected) > 0 {
+		rejectedIds := make([]uint64, len(result.Rejected))
+		for i, rejection := range result.Rejected {
+			rejectedIds[i] = uint64(*rejection.Podcast.ItunesID)
+			logger.Error.Printf("Podcast %d was rejected by the database: %v\n", rejectedIds[i], rejection.Err)
+		}
+		o.Fail(rejectedIds, models.FailureRejected, 0)
+	}
+
+	// Unsaved results are looked up again later rather than lost
+	if err != nil {
 		logger.Error.Printf(
-			"Save failed: Unable to save results to database: %v\nPausing further fetches and retrying...\n",
-			tx.Error,
+			"Failed to save %d results (%s error): %v\n",
+			len(result.Unsaved),
+			service.ClassifySaveError(err),
+			err,
 		)
-		o.fetcher.CommandChannel <- podcast.Pause
+		o.Requeue(itunesIds(result.Unsaved), models.FailurePersistError, 0)
+	}
+}
 
-		err := utils.IncrementalBackoff(func() error {
-			tx.CreateInBatches(podcasts, 1000)
-			return tx.Error
-		})
+func itunesIds(podcasts []models.Podcast) []uint64 {
+	ids := make([]uint64, 0, len(podcasts))
+	for _, p := range podcasts {
+		if p.ItunesID != nil {
+			ids = append(ids, uint64(*p.ItunesID))
+		}
+	}
 
-		if err != nil {
-			tx.Rollback()
-			logger.Error.Fatalln("Save failed: Unable to save results to database with incremental backoff")
+	return ids
+}
+
+// Dead letters ids. IDs that can't be recorded in the database are kept in
+// failedIds and written to the failed list file on shutdown instead
+func (o *orchestrator) Fail(ids []uint64, category models.FailureCategory, status int) {
+	if len(ids) == 0 {
+		return
+	}
+	o.failedCount.Add(int64(len(ids)))
+	o.forgetAttempts(ids)
+	o.abandonBisected(ids)
+
+	failures := make([]models.DeadLetter, len(ids))
+	for i, id := range ids {
+		failures[i] = models.DeadLetter{
+			ItunesID:   id,
+			Category:   category,
+			HttpStatus: status,
+			CrawlRunID: &o.run.ID,
+		}
+	}
+
+	store, err := database.GetStore()
+	if err == nil {
+		err = store.RecordFailures(failures)
+	}
+	if err != nil {
+		logger.Error.Printf("Failed to dead letter %d IDs (%s): %v\n", len(ids), category, err)
+		o.failedIds.Put(ids...)
+	}
+}
+
+// Schedules ids for another lookup after an exponential backoff. IDs that
+// have used up their attempts are dead lettered with the given category
+func (o *orchestrator) Requeue(ids []uint64, category models.FailureCategory, status int) {
+	if len(ids) == 0 {
+		return
+	}
+	o.abandonBisected(ids)
+
+	// Group by attempt count so IDs that failed together share a backoff
+	exhausted := make([]uint64, 0)
+	retries := make(map[uint32][]uint64)
+	o.attemptsMutex.Lock()
+	for _, id := range ids {
+		attempts := o.attempts[id]
+		if attempts >= o.maxAttempts {
+			exhausted = append(exhausted, id)
+			continue
 		}
-		o.fetcher.CommandChannel <- podcast.Resume
+		retries[attempts] = append(retries[attempts], id)
 	}
+	o.attemptsMutex.Unlock()
 
-	tx.Commit()
-	if tx.Error != nil {
-		logger.Error.Fatalf("Failed to save %d results to database: %v\n", resultsCount, tx.Error)
+	for attempts, retryIds := range retries {
+		wait := utils.ExponentialBackoff(int(attempts), o.backoffBase, o.backoffMax)
+		nextAttemptAt := time.Now().Add(wait)
+		o.updateQueue(retryIds, func(db *gorm.DB, ids []uint64) error {
+			return service.ScheduleRetry(db, ids, nextAttemptAt)
+		})
+		// Reprocessing saves without a fetcher. The queue alone schedules
+		// the retry then
+		if o.fetcher != nil {
+			o.fetcher.Delay(nextAttemptAt, retryIds...)
+		}
+		o.requeuedCount.Add(int64(len(retryIds)))
 	}
 
-	logger.Success.Printf("Successfully saved %d/%d results to database\n", tx.RowsAffected, resultsCount)
+	if len(exhausted) > 0 {
+		logger.Warn.Printf(
+			"%d IDs used up their %d lookup attempts and will be dead lettered\n",
+			len(exhausted),
+			o.maxAttempts,
+		)
+		o.Fail(exhausted, category, status)
+	}
 }
 
-func (o *orchestrator) Succeed(results []podcast.ItunesResult) {
-	o.payloads.Put(results...)
-	payloadsCount := o.payloads.Length()
-	if payloadsCount > o.saveTreshold {
-		logger.Info.Printf("Saving %d results to database...\n", payloadsCount)
-		o.Save()
+// Drops attempt counts of IDs that no longer need retrying
+func (o *orchestrator) forgetAttempts(ids []uint64) {
+	o.attemptsMutex.Lock()
+	for _, id := range ids {
+		delete(o.attempts, id)
 	}
+	o.attemptsMutex.Unlock()
 }
 
-func (o *orchestrator) Fail(ids []uint64) {
-	o.failedIds.Put(ids...)
+func (o *orchestrator) onBatch(ids []uint64) {
+	o.attemptsMutex.Lock()
+	for _, id := range ids {
+		o.attempts[id]++
+	}
+	o.attemptsMutex.Unlock()
+
+	o.updateQueue(ids, service.MarkInFlight)
 }
 
-func (o *orchestrator) Requeue(ids []uint64) {
-	o.fetcher.Append(ids...)
-	o.fetcher.Shuffle()
+// Applies a crawl queue update. Failures are logged rather than fatal since
+// the next start returns in flight IDs to pending and marks saved IDs as done
+func (o *orchestrator) updateQueue(ids []uint64, update func(*gorm.DB, []uint64) error) {
+	if len(ids) == 0 {
+		return
+	}
+
+	db, err := database.GetInstance()
+	if err == nil {
+		err = update(db, ids)
+	}
+	if err != nil {
+		logger.Error.Printf("Failed to update %d IDs in the crawl queue: %v\n", len(ids), err)
+	}
 }
 
 func (o *orchestrator) onFetchResponse(msg podcast.FetchResponse) {
 	if !msg.Success {
-		go o.onFetchFail(msg.IsBodyValid, msg.Data.Url)
+		o.onFetchFail(msg.IsBodyValid, msg.Status, msg.Data.Url)
 		return
 	}
 
-	go o.onFetchSuccess(msg.Data.Url, msg.Data.Payload)
+	o.onFetchSuccess(msg.Status, msg.Data.Url, msg.Data.Payload)
 }
 
-func (o *orchestrator) onFetchSuccess(url string, payload string) {
+func (o *orchestrator) onFetchSuccess(status int, url string, payload string) {
+	o.archiveResponse(status, url, payload)
+
 	p, err := podcast.ParseLookupResponse(payload)
 	ids := podcast.ExtractLookupIDs(url)
 	if err != nil {
-		o.Fail(ids) // TODO: Find a way to do individual validation on result entries
+		o.Fail(ids, models.FailureMalformedBody, status) // TODO: Find a way to do individual validation on result entries
+		return
+	}
+
+	resultIds := make([]uint64, len(p.Results))
+	for i, result := range p.Results {
+		resultIds[i] = uint64(result.CollectionId)
 	}
+	successes, failures := validateResults(p.Results)
 
-	failures := make([]uint64, 0, p.ResultCount)
-	successes := make([]podcast.ItunesResult, 0, p.ResultCount)
-	for _, result := range p.Results {
+	if o.isBisection(ids) {
+		o.resolveBisected(resultIds, true)
+	} else {
+		o.sizer.Observe(len(ids), len(resultIds))
+	}
+
+	if len(failures) > 0 {
+		o.Fail(failures, models.FailureEmptyCollectionName, status)
+	}
+
+	if len(successes) > 0 {
+		logger.Success.Printf("Parsed %d results\n", len(successes))
+		o.parsedResults(successes)
+	}
+
+	o.handleUnfetched(ids, resultIds, status)
+}
+
+// Splits results into ones that can be saved and IDs of ones without a
+// collection name. Missing censored names fall back to the collection name
+func validateResults(results []podcast.ItunesResult) ([]podcast.ItunesResult, []uint64) {
+	failures := make([]uint64, 0)
+	successes := make([]podcast.ItunesResult, 0, len(results))
+	for _, result := range results {
 		isCollectionNameEmpty := result.CollectionName == nil || len(strings.TrimSpace(*result.CollectionName)) == 0
 		if isCollectionNameEmpty {
 			failures = append(failures, uint64(result.CollectionId))
@@ -183,43 +682,134 @@ func (o *orchestrator) onFetchSuccess(url string, payload string) {
 		successes = append(successes, result)
 	}
 
-	if len(failures) > 0 {
-		go o.Fail(failures)
-	}
+	return successes, failures
+}
 
-	if len(successes) > 0 {
-		logger.Success.Printf("Parsed %d results, %d total\n", len(successes), o.payloads.Length())
-		go o.Succeed(successes)
+// Archives a lookup response body when archiving is enabled. Failures are
+// only logged since the response has been received either way
+func (o *orchestrator) archiveResponse(status int, url string, payload string) {
+	if o.archive == nil {
+		return
 	}
 
-	go o.handleUnfetched(ids, successes)
+	if _, err := o.archive.Put(url, status, []byte(payload), time.Now()); err != nil {
+		logger.Error.Printf("Failed to archive response of `%s`: %v\n", url, err)
+	}
 }
 
-func (o *orchestrator) onFetchFail(isBodyValid bool, url string) {
+func (o *orchestrator) onFetchFail(isBodyValid bool, status int, url string) {
 	failedIds := podcast.ExtractLookupIDs(url)
 	if !isBodyValid {
 		logger.Error.Printf(
 			"Fetch failed for %d IDs. Entries will not be requeued due to malformed response bodies",
 			len(failedIds),
 		)
-		o.Fail(failedIds)
+		o.Fail(failedIds, models.FailureMalformedBody, status)
+		return
+	}
+
+	if !isRetryableStatus(status) {
+		logger.Error.Printf(
+			"Fetch failed for %d IDs with status %d. Entries will not be requeued",
+			len(failedIds),
+			status,
+		)
+		o.Fail(failedIds, models.FailureTransportError, status)
 		return
 	}
 
 	logger.Error.Printf(
-		"Fetch failed for %d IDs. Entries requeued",
+		"Fetch failed for %d IDs with status %d. Entries requeued",
 		len(failedIds),
+		status,
 	)
-	o.Requeue(failedIds)
+	o.Requeue(failedIds, models.FailureTransportError, status)
 }
 
-func (o *orchestrator) handleUnfetched(ids []uint64, results []podcast.ItunesResult) {
-	resultIds := make([]uint64, len(results))
-	for i := range results {
-		resultIds[i] = uint64(results[i].CollectionId)
+// Client errors other than throttling and timeouts won't succeed on a retry
+func isRetryableStatus(status int) bool {
+	switch {
+	case status == http.StatusForbidden,
+		status == http.StatusRequestTimeout,
+		status == http.StatusTooManyRequests:
+		return true
+	case status >= 400 && status < 500:
+		return false
+	default:
+		return true
 	}
+}
 
+// Bisects IDs missing from a lookup result. Missing IDs are looked up again
+// in two halves until they are found or looked up on their own, at which
+// point they are known to be unavailable and are dead lettered
+func (o *orchestrator) handleUnfetched(ids []uint64, resultIds []uint64, status int) {
 	unfetchedIds := utils.LeftDiff(ids, resultIds)
-	logger.Info.Printf("Requeued %d ids that were missing from result", len(unfetchedIds))
-	o.Requeue(unfetchedIds)
+	if len(unfetchedIds) == 0 {
+		return
+	}
+
+	if len(ids) == 1 {
+		logger.Warn.Printf("ID %d is missing from its own lookup and is unavailable\n", unfetchedIds[0])
+		o.resolveBisected(unfetchedIds, false)
+		o.Fail(unfetchedIds, models.FailureUnavailable, status)
+		return
+	}
+
+	o.bisectingMutex.Lock()
+	for _, id := range unfetchedIds {
+		if _, ok := o.bisecting[id]; !ok {
+			o.bisecting[id] = len(ids)
+		}
+	}
+	o.bisectingMutex.Unlock()
+
+	groups := podcast.Bisect(unfetchedIds)
+	logger.Info.Printf(
+		"%d/%d ids were missing from result. Bisecting into %d lookups",
+		len(unfetchedIds),
+		len(ids),
+		len(groups),
+	)
+	o.fetcher.AppendGroups(groups...)
+}
+
+// Whether ids came from a bisection group rather than a pool batch
+func (o *orchestrator) isBisection(ids []uint64) bool {
+	if len(ids) == 0 {
+		return false
+	}
+
+	o.bisectingMutex.Lock()
+	_, ok := o.bisecting[ids[0]]
+	o.bisectingMutex.Unlock()
+
+	return ok
+}
+
+// Reports the outcome of bisected ids to the batch sizer. found reports
+// whether a smaller lookup returned them
+func (o *orchestrator) resolveBisected(ids []uint64, found bool) {
+	o.bisectingMutex.Lock()
+	defer o.bisectingMutex.Unlock()
+
+	for _, id := range ids {
+		if origin, ok := o.bisecting[id]; ok {
+			o.sizer.Resolve(origin, found)
+			delete(o.bisecting, id)
+		}
+	}
+}
+
+// Stops tracking bisected ids whose lookups failed before an outcome was known
+func (o *orchestrator) abandonBisected(ids []uint64) {
+	o.bisectingMutex.Lock()
+	defer o.bisectingMutex.Unlock()
+
+	for _, id := range ids {
+		if origin, ok := o.bisecting[id]; ok {
+			o.sizer.Abandon(origin)
+			delete(o.bisecting, id)
+		}
+	}
 }
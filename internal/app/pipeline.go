@@ -0,0 +1,146 @@
+package app
+
+import (
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+// Starts the parse, convert and persist stages. Each stage has a fixed
+// number of workers and a bounded channel to the next one, so a stage that
+// falls behind blocks the stages before it. The fetcher only frees a request
+// slot once its response is picked up, so a slow Save ends up holding back
+// new lookups
+func (o *orchestrator) startPipeline(parseWorkers int, convertWorkers int, bufferSize int) {
+	o.parsed = make(chan []podcast.ItunesResult, bufferSize)
+	o.converted = make(chan []models.Podcast, bufferSize)
+	o.stopParsing = make(chan struct{})
+	o.flush = make(chan struct{}, 1)
+	o.persisted = make(chan struct{})
+
+	for i := 0; i < parseWorkers; i++ {
+		o.parseWorkers.Add(1)
+		go o.parseStage()
+	}
+	for i := 0; i < convertWorkers; i++ {
+		o.convertWorkers.Add(1)
+		go o.convertStage()
+	}
+	go o.persistStage()
+
+	logger.Info.Printf(
+		"Pipeline started with %d parse workers, %d convert workers and %d batches of buffering per stage\n",
+		parseWorkers,
+		convertWorkers,
+		bufferSize,
+	)
+}
+
+// Closes the pipeline stage by stage once the fetcher has stopped, letting
+// each stage finish what it was handed before the next one is closed
+func (o *orchestrator) drainPipeline() {
+	close(o.stopParsing)
+	o.parseWorkers.Wait()
+	close(o.parsed)
+	o.convertWorkers.Wait()
+	close(o.converted)
+	<-o.persisted
+}
+
+// Whether every lookup fired so far has made it through the pipeline and no
+// IDs are left to fetch. Counters are read in pipeline order since handling
+// a response may hand work to a later stage or back to the fetcher
+func (o *orchestrator) isIdle() bool {
+	handled := o.handled.Load()
+	return handled == o.fetcher.Issued() && o.queued.Load() == 0 && o.fetcher.Length() == 0
+}
+
+// Validates responses and passes results on to conversion. Failed lookups
+// are requeued or dead lettered here
+func (o *orchestrator) parseStage() {
+	defer o.parseWorkers.Done()
+
+	for {
+		select {
+		case r := <-o.fetcher.ResponseChannel:
+			o.onFetchResponse(r)
+			o.handled.Add(1)
+		case <-o.stopParsing:
+			return
+		}
+	}
+}
+
+// Hands validated results to the convert stage
+func (o *orchestrator) parsedResults(results []podcast.ItunesResult) {
+	o.queued.Add(1)
+	o.parsed <- results
+}
+
+// Turns parsed results into database models
+func (o *orchestrator) convertStage() {
+	defer o.convertWorkers.Done()
+
+	for results := range o.parsed {
+		podcasts := make([]models.Podcast, len(results))
+		for i, result := range results {
+			podcasts[i] = service.PodcastFromItunesResult(result)
+		}
+
+		o.queued.Add(1)
+		o.converted <- podcasts
+		o.queued.Add(-1)
+	}
+}
+
+// Buffers converted podcasts and saves them once there are more than
+// saveTreshold, or when asked to flush. Buffered batches stay counted in
+// queued until their save returns, so IDs a save requeues are back in the
+// fetcher before the run can look idle. Whatever is buffered when the
+// pipeline closes is saved on the way out
+func (o *orchestrator) persistStage() {
+	defer close(o.persisted)
+
+	buffer := make([]models.Podcast, 0)
+	batches := int64(0)
+	save := func(format string) {
+		logger.Info.Printf(format, len(buffer))
+		o.Save(buffer)
+		o.queued.Add(-batches)
+		buffer = make([]models.Podcast, 0)
+		batches = 0
+	}
+
+	for {
+		select {
+		case podcasts, ok := <-o.converted:
+			if !ok {
+				if len(buffer) > 0 {
+					save("Saving %d buffered results to database...\n")
+				}
+				return
+			}
+			buffer = append(buffer, podcasts...)
+			batches++
+
+			if len(buffer) > o.saveTreshold {
+				save("Saving %d results to database...\n")
+			}
+		case <-o.flush:
+			if len(buffer) > 0 {
+				save("Saving %d buffered results to database...\n")
+			}
+		}
+	}
+}
+
+// Asks the persist stage to save what it has buffered without waiting for
+// saveTreshold. Used once the fetcher has drained, since a buffer that never
+// fills would otherwise keep the run from going idle
+func (o *orchestrator) flushBuffered() {
+	select {
+	case o.flush <- struct{}{}:
+	default:
+	}
+}
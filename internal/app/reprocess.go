@@ -0,0 +1,74 @@
+package app
+
+import (
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/archive"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+// Saves every archived lookup response again without touching the network,
+// oldest first so the latest response of each podcast is the one kept.
+// Returns the exit code
+func Reprocess(saveTreshold int) int {
+	archiveDir := config.AppConfig.ArchiveDir
+	a, err := archive.Open(archiveDir)
+	if err != nil {
+		logger.Error.Printf("Failed to open response archive: %v\n", err)
+		return ExitError
+	}
+
+	o := newOrchestrator(saveTreshold)
+	if err := o.startRun(models.CrawlRun{Mode: models.RunReprocess, InputFile: &archiveDir}, 0); err != nil {
+		logger.Error.Printf("Failed to record crawl run: %v\n", err)
+		return ExitError
+	}
+
+	// Archived responses go straight to conversion. A single convert worker
+	// keeps them in archive order
+	o.startPipeline(0, 1, config.AppConfig.StageBufferSize)
+
+	var responses, skipped int
+	err = a.Entries(func(entry archive.Entry) error {
+		responses++
+
+		body, err := a.Body(entry.Hash)
+		if err != nil {
+			logger.Error.Printf("Skipping archived response of `%s`: %v\n", entry.Url, err)
+			skipped++
+			return nil
+		}
+
+		p, err := podcast.ParseLookupResponse(string(body))
+		if err != nil {
+			logger.Error.Printf("Skipping malformed archived response of `%s`: %v\n", entry.Url, err)
+			skipped++
+			return nil
+		}
+
+		successes, failures := validateResults(p.Results)
+		if len(failures) > 0 {
+			logger.Warn.Printf("Skipping %d results without a collection name from `%s`\n", len(failures), entry.Url)
+		}
+		if len(successes) > 0 {
+			o.parsedResults(successes)
+		}
+
+		return nil
+	})
+
+	o.drainPipeline()
+
+	code := ExitOK
+	if err != nil {
+		logger.Error.Printf("Failed to read response archive: %v\n", err)
+		code = ExitError
+	} else if skipped > 0 {
+		code = ExitIncomplete
+	}
+	logger.Info.Printf("Reprocessed %d archived responses, %d skipped\n", responses, skipped)
+
+	o.finishRun(code, 0)
+	return code
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+)
+
+// Printed once a crawl run has shut down
+type runSummary struct {
+	ID                 string           `json:"id"`
+	Mode               models.RunMode   `json:"mode"`
+	InputFile          *string          `json:"input_file,omitempty"`
+	Status             models.RunStatus `json:"status"`
+	ExitCode           int              `json:"exit_code"`
+	StartedAt          time.Time        `json:"started_at"`
+	FinishedAt         time.Time        `json:"finished_at"`
+	DurationSeconds    float64          `json:"duration_seconds"`
+	IDs                int64            `json:"ids"`
+	Saved              int64            `json:"saved"`
+	Failed             int64            `json:"failed"`
+	Requeued           int64            `json:"requeued"`
+	UnrecordedFailures int              `json:"unrecorded_failures"`
+	LookupSize         int              `json:"lookup_size"`
+}
+
+// Records the start of the crawl described by run, with ids pending IDs
+func (o *orchestrator) startRun(run models.CrawlRun, ids int64) error {
+	run.ID = utils.NewUUID()
+	run.Status = models.RunRunning
+	run.IDCount = ids
+	run.StartedAt = time.Now()
+	o.run = run
+
+	db, err := database.GetInstance()
+	if err != nil {
+		return err
+	}
+	if err := service.CreateRun(db, &o.run); err != nil {
+		return err
+	}
+
+	logger.Info.Printf("Crawl run %s started with %d IDs\n", o.run.ID, ids)
+	return nil
+}
+
+// Writes the current counters to the run's row. Failures are only logged
+// since the final counters are written again when the run finishes
+func (o *orchestrator) recordProgress() {
+	run := o.run
+	run.Saved = o.savedCount.Load()
+	run.Failed = o.failedCount.Load()
+	run.Requeued = o.requeuedCount.Load()
+
+	db, err := database.GetInstance()
+	if err == nil {
+		err = service.UpdateRun(db, &run)
+	}
+	if err != nil {
+		logger.Error.Printf("Failed to update crawl run %s: %v\n", run.ID, err)
+	}
+}
+
+// Records how the run ended and prints its summary
+func (o *orchestrator) finishRun(code int, unrecordedFailures int) {
+	finishedAt := time.Now()
+	o.run.Status = runStatus(code)
+	o.run.FinishedAt = &finishedAt
+	o.recordProgress()
+
+	summary := runSummary{
+		ID:                 o.run.ID,
+		Mode:               o.run.Mode,
+		InputFile:          o.run.InputFile,
+		Status:             o.run.Status,
+		ExitCode:           code,
+		StartedAt:          o.run.StartedAt,
+		FinishedAt:         finishedAt,
+		DurationSeconds:    finishedAt.Sub(o.run.StartedAt).Seconds(),
+		IDs:                o.run.IDCount,
+		Saved:              o.savedCount.Load(),
+		Failed:             o.failedCount.Load(),
+		Requeued:           o.requeuedCount.Load(),
+		UnrecordedFailures: unrecordedFailures,
+		LookupSize:         o.sizer.Size(),
+	}
+
+	if err := printSummary(summary, config.AppConfig.RunSummaryFormat); err != nil {
+		logger.Error.Printf("Failed to print run summary: %v\n", err)
+	}
+}
+
+func runStatus(code int) models.RunStatus {
+	switch code {
+	case ExitOK:
+		return models.RunCompleted
+	case ExitIncomplete:
+		return models.RunIncomplete
+	case ExitInterrupted:
+		return models.RunInterrupted
+	default:
+		return models.RunFailed
+	}
+}
+
+func printSummary(summary runSummary, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	}
+
+	inputFile := "-"
+	if summary.InputFile != nil {
+		inputFile = *summary.InputFile
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Run\t%s\n", summary.ID)
+	fmt.Fprintf(w, "Mode\t%s\n", summary.Mode)
+	fmt.Fprintf(w, "Input file\t%s\n", inputFile)
+	fmt.Fprintf(w, "Status\t%s (exit code %d)\n", summary.Status, summary.ExitCode)
+	fmt.Fprintf(w, "Started\t%s\n", summary.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Finished\t%s\n", summary.FinishedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Duration\t%v\n", time.Duration(summary.DurationSeconds*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(w, "IDs\t%d\n", summary.IDs)
+	fmt.Fprintf(w, "Saved\t%d\n", summary.Saved)
+	fmt.Fprintf(w, "Failed\t%d\n", summary.Failed)
+	fmt.Fprintf(w, "Requeued\t%d\n", summary.Requeued)
+	fmt.Fprintf(w, "Unrecorded failures\t%d\n", summary.UnrecordedFailures)
+	fmt.Fprintf(w, "Lookup size\t%d\n", summary.LookupSize)
+
+	return w.Flush()
+}
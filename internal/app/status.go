@@ -0,0 +1,154 @@
+package app
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+)
+
+// Printed by the status command
+type statusReport struct {
+	Podcasts    int64                            `json:"podcasts"`
+	Queue       map[models.QueueState]int64      `json:"queue"`
+	DeadLetters map[models.FailureCategory]int64 `json:"dead_letters"`
+	Runs        []runStatusReport                `json:"runs"`
+}
+
+type runStatusReport struct {
+	ID         string           `json:"id"`
+	Mode       models.RunMode   `json:"mode"`
+	Status     models.RunStatus `json:"status"`
+	IDs        int64            `json:"ids"`
+	Saved      int64            `json:"saved"`
+	Failed     int64            `json:"failed"`
+	Requeued   int64            `json:"requeued"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+}
+
+// Queue states in the order an ID moves through them
+var queueStates = []models.QueueState{
+	models.QueuePending,
+	models.QueueInFlight,
+	models.QueueDone,
+	models.QueueFailed,
+}
+
+func showStatus(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	runs := flags.Int("runs", 5, "Number of recent runs to show")
+	asJson := flags.Bool("json", false, "Print the status as JSON")
+	if err := flags.Parse(args); err != nil {
+		return ExitError
+	}
+
+	db, err := database.GetInstance()
+	if err != nil {
+		logger.Error.Printf("Unable to get database instance: %v\n", err)
+		return ExitError
+	}
+
+	var report statusReport
+	if report.Podcasts, err = service.CountPodcasts(db); err != nil {
+		logger.Error.Printf("Failed to count podcasts: %v\n", err)
+		return ExitError
+	}
+	if report.Queue, err = service.QueueCounts(db); err != nil {
+		logger.Error.Printf("Failed to count the crawl queue: %v\n", err)
+		return ExitError
+	}
+	if report.DeadLetters, err = service.DeadLetterCounts(db); err != nil {
+		logger.Error.Printf("Failed to count dead letters: %v\n", err)
+		return ExitError
+	}
+	recent, err := service.RecentRuns(db, *runs)
+	if err != nil {
+		logger.Error.Printf("Failed to list recent runs: %v\n", err)
+		return ExitError
+	}
+	report.Runs = make([]runStatusReport, len(recent))
+	for i, run := range recent {
+		report.Runs[i] = runStatusReport{
+			ID:         run.ID,
+			Mode:       run.Mode,
+			Status:     run.Status,
+			IDs:        run.IDCount,
+			Saved:      run.Saved,
+			Failed:     run.Failed,
+			Requeued:   run.Requeued,
+			StartedAt:  run.StartedAt,
+			FinishedAt: run.FinishedAt,
+		}
+	}
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			logger.Error.Printf("Failed to print status: %v\n", err)
+			return ExitError
+		}
+		return ExitOK
+	}
+
+	printStatus(report)
+	return ExitOK
+}
+
+func printStatus(report statusReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Podcasts\t%d\n", report.Podcasts)
+
+	fmt.Fprintln(w, "\nCrawl queue")
+	for _, state := range queueStates {
+		fmt.Fprintf(w, "  %s\t%d\n", state, report.Queue[state])
+	}
+
+	categories := make([]string, 0, len(report.DeadLetters))
+	for category := range report.DeadLetters {
+		categories = append(categories, string(category))
+	}
+	sort.Strings(categories)
+
+	fmt.Fprintln(w, "\nDead letters")
+	if len(categories) == 0 {
+		fmt.Fprintln(w, "  none\t")
+	}
+	for _, category := range categories {
+		fmt.Fprintf(w, "  %s\t%d\n", category, report.DeadLetters[models.FailureCategory(category)])
+	}
+	w.Flush()
+
+	fmt.Println("\nRecent runs")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tRUN\tMODE\tSTATUS\tIDS\tSAVED\tFAILED\tREQUEUED\tFINISHED")
+	for _, run := range report.Runs {
+		finished := "-"
+		if run.FinishedAt != nil {
+			finished = run.FinishedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n",
+			run.StartedAt.Format(time.RFC3339),
+			run.ID,
+			run.Mode,
+			run.Status,
+			run.IDs,
+			run.Saved,
+			run.Failed,
+			run.Requeued,
+			finished,
+		)
+	}
+	w.Flush()
+}
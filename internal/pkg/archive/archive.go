@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const indexFile = "index.jsonl"
+
+// An archived lookup response. Bodies are stored once per distinct content,
+// so entries of identical responses share a hash
+type Entry struct {
+	Hash      string    `json:"hash"` // Hex encoded SHA-256 of the body
+	Url       string    `json:"url"`
+	Status    int       `json:"status"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// Lookup response bodies stored gzipped under their hash in a directory,
+// with an index listing every response in the order it was archived
+type Archive struct {
+	dir        string
+	indexMutex sync.Mutex
+}
+
+// Opens the archive in dir, creating the directory if it doesn't exist
+func Open(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &Archive{dir: dir}, nil
+}
+
+// Stores body unless the same content was archived before, then adds an
+// entry for it to the index
+func (a *Archive) Put(url string, status int, body []byte, fetchedAt time.Time) (Entry, error) {
+	sum := sha256.Sum256(body)
+	entry := Entry{
+		Hash:      hex.EncodeToString(sum[:]),
+		Url:       url,
+		Status:    status,
+		FetchedAt: fetchedAt,
+	}
+
+	if err := a.writeBody(entry.Hash, body); err != nil {
+		return entry, err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+
+	a.indexMutex.Lock()
+	defer a.indexMutex.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(a.dir, indexFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return entry, err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return entry, err
+}
+
+// Returns the body archived under hash
+func (a *Archive) Body(hash string) ([]byte, error) {
+	file, err := os.Open(a.bodyPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// Calls fn with every index entry in the order they were archived, stopping
+// at the first error
+func (a *Archive) Entries(fn func(Entry) error) error {
+	file, err := os.Open(filepath.Join(a.dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Bodies are spread over subdirectories named after the first two characters
+// of their hash to keep directories small
+func (a *Archive) bodyPath(hash string) string {
+	return filepath.Join(a.dir, hash[:2], hash+".gz")
+}
+
+// Writes body to a temporary file first so a crash never leaves a truncated
+// body under its hash
+func (a *Archive) writeBody(hash string, body []byte) error {
+	path := a.bodyPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	writer := gzip.NewWriter(temp)
+	if _, err := writer.Write(body); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,107 @@
+package archive_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/archive"
+)
+
+func TestArchive(t *testing.T) {
+	fetchedAt := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Round trips bodies and lists entries in order", func(t *testing.T) {
+		a, err := archive.Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bodies := []string{`{"resultCount":1}`, `{"resultCount":2}`}
+		for i, body := range bodies {
+			_, err := a.Put("https://itunes.apple.com/lookup?id="+strconv.Itoa(i+1), 200, []byte(body), fetchedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		i := 0
+		err = a.Entries(func(entry archive.Entry) error {
+			body, err := a.Body(entry.Hash)
+			if err != nil {
+				return err
+			}
+			if string(body) != bodies[i] {
+				t.Errorf("entry %d body = %s, want %s", i, body, bodies[i])
+			}
+			if entry.Status != 200 || !entry.FetchedAt.Equal(fetchedAt) {
+				t.Errorf("entry %d = %+v, want status 200 fetched at %v", i, entry, fetchedAt)
+			}
+			i++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i != len(bodies) {
+			t.Errorf("listed %d entries, want %d", i, len(bodies))
+		}
+	})
+
+	t.Run("Stores identical bodies once", func(t *testing.T) {
+		dir := t.TempDir()
+		a, err := archive.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first, err := a.Put("https://itunes.apple.com/lookup?id=1", 200, []byte("same"), fetchedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := a.Put("https://itunes.apple.com/lookup?id=2", 200, []byte("same"), fetchedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Hash != second.Hash {
+			t.Errorf("hashes differ for identical bodies: %s, %s", first.Hash, second.Hash)
+		}
+
+		files, err := filepath.Glob(filepath.Join(dir, "*", "*.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Errorf("found %d stored bodies, want 1", len(files))
+		}
+	})
+
+	t.Run("Lists nothing for an empty archive", func(t *testing.T) {
+		a, err := archive.Open(filepath.Join(t.TempDir(), "missing"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = a.Entries(func(entry archive.Entry) error {
+			t.Errorf("unexpected entry %+v", entry)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Fails for unknown hashes", func(t *testing.T) {
+		a, err := archive.Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = a.Body("0000000000000000000000000000000000000000000000000000000000000000")
+		if !os.IsNotExist(err) {
+			t.Errorf("Body() error = %v, want not exist", err)
+		}
+	})
+}
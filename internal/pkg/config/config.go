@@ -2,6 +2,9 @@ package config
 
 import (
 	_ "embed"
+	"flag"
+	"fmt"
+	"os"
 
 	"github.com/creasty/defaults"
 	"github.com/go-playground/validator/v10"
@@ -17,40 +20,122 @@ var v *validator.Validate
 
 type Config struct {
 	Database struct {
-		DBName   string `yaml:"dbName" validate:"required"`
-		Host     string `yaml:"host" default:"http://localhost" validate:"required"`
-		Password string `yaml:"password" validate:"required"`
-		Port     uint16 `yaml:"port" default:"80" validate:"required"`
-		User     string `yaml:"user" default:"postgres" validate:"required"`
+		Backend                 string `yaml:"backend" default:"postgres" validate:"required,oneof=postgres sqlite"`
+		URL                     string `yaml:"url"` // Postgres connection URL used instead of the settings below. Falls back to DATABASE_URL
+		DBName                  string `yaml:"dbName" validate:"required_if=Backend postgres"`
+		Host                    string `yaml:"host" default:"localhost" validate:"required_if=Backend postgres"`
+		Password                string `yaml:"password" validate:"excluded_with=PasswordFile"`
+		PasswordFile            string `yaml:"passwordFile"` // File holding the password, like a mounted secret
+		Port                    uint16 `yaml:"port" default:"5432" validate:"required_if=Backend postgres"`
+		User                    string `yaml:"user" default:"postgres" validate:"required_if=Backend postgres"`
+		SSLMode                 string `yaml:"sslMode" default:"disable" validate:"required,oneof=disable allow prefer require verify-ca verify-full"`
+		Path                    string `yaml:"path" default:"data/podcasts.db" validate:"required_if=Backend sqlite"` // SQLite database file
+		MaxOpenConns            int    `yaml:"maxOpenConns" default:"10" validate:"required,min=1"`
+		MaxIdleConns            int    `yaml:"maxIdleConns" default:"5" validate:"min=0,ltefield=MaxOpenConns"`
+		ConnMaxLifetimeSeconds  int    `yaml:"connMaxLifetimeSeconds" default:"1800" validate:"min=0"` // 0 keeps connections forever
+		ConnMaxIdleTimeSeconds  int    `yaml:"connMaxIdleTimeSeconds" default:"300" validate:"min=0"`  // 0 keeps idle connections forever
+		ConnectTimeoutSeconds   int    `yaml:"connectTimeoutSeconds" default:"10" validate:"required,min=1"`
+		StatementTimeoutSeconds int    `yaml:"statementTimeoutSeconds" default:"60" validate:"min=0"` // 0 disables the timeout
 	} `yaml:"database" validate:"required"`
-	ConcurrentFetchBatchSize int    `yaml:"concurrentFetchBatchSize" default:"100" validate:"required"`
-	SingleFetchIDsCount      int    `yaml:"singleFetchIdsCount" default:"100" validate:"required"`
-	SaveTreshold             int    `yaml:"saveTreshold" default:"50000" validate:"required"`
-	PodcastListFile          string `yaml:"podcastListFile" default:"data/podcasts.txt" validate:"required"`
-	LogDestination           string `yaml:"logDestination" default:"logs/" validate:"required"`
+	ConcurrentFetchBatchSize   int    `yaml:"concurrentFetchBatchSize" default:"100" validate:"required,gtefield=MinConcurrentFetches"`
+	MinConcurrentFetches       int    `yaml:"minConcurrentFetches" default:"1" validate:"required,min=1"`
+	MaxConcurrentFetches       int    `yaml:"maxConcurrentFetches" default:"200" validate:"required,gtefield=ConcurrentFetchBatchSize"`
+	ConcurrencyIntervalSeconds int    `yaml:"concurrencyIntervalSeconds" default:"30" validate:"required,min=1"`
+	TargetLatencyMs            int    `yaml:"targetLatencyMs" default:"3000" validate:"required,min=1"`
+	MaxErrorRatePercent        int    `yaml:"maxErrorRatePercent" default:"5" validate:"min=0,max=100"`
+	RequestTimeoutSeconds      int    `yaml:"requestTimeoutSeconds" default:"30" validate:"required,min=1"`
+	UserAgent                  string `yaml:"userAgent" default:"podcrawler/0.0.1"`
+	RequestsPerMinute          int    `yaml:"requestsPerMinute" default:"20" validate:"required,min=1"`
+	RequestBurst               int    `yaml:"requestBurst" default:"5" validate:"required,min=1"`
+	ThrottleCooldownSeconds    int    `yaml:"throttleCooldownSeconds" default:"60" validate:"required,min=1"`
+	SingleFetchIDsCount        int    `yaml:"singleFetchIdsCount" default:"100" validate:"required"`
+	AdaptiveFetchIDsCount      bool   `yaml:"adaptiveFetchIdsCount" default:"true"`
+	FetchIDsCountWindow        int    `yaml:"fetchIdsCountWindow" default:"10" validate:"required,min=1"`
+	SaveTreshold               int    `yaml:"saveTreshold" default:"50000" validate:"required"`
+	PersistenceBackend         string `yaml:"persistenceBackend" default:"gorm" validate:"required,oneof=gorm copy"`
+	ParseWorkers               int    `yaml:"parseWorkers" default:"4" validate:"required,min=1"`
+	ConvertWorkers             int    `yaml:"convertWorkers" default:"4" validate:"required,min=1"`
+	StageBufferSize            int    `yaml:"stageBufferSize" default:"16" validate:"required,min=1"`
+	SaveAttempts               int    `yaml:"saveAttempts" default:"4" validate:"required,min=1"`
+	SaveBackoffSeconds         int    `yaml:"saveBackoffSeconds" default:"5" validate:"required,min=1"`
+	SaveBackoffMaxSeconds      int    `yaml:"saveBackoffMaxSeconds" default:"60" validate:"required,gtefield=SaveBackoffSeconds"`
+	MaxFetchAttempts           int    `yaml:"maxFetchAttempts" default:"5" validate:"required,min=1"`
+	RetryBackoffSeconds        int    `yaml:"retryBackoffSeconds" default:"30" validate:"required,min=1"`
+	RetryBackoffMaxSeconds     int    `yaml:"retryBackoffMaxSeconds" default:"1800" validate:"required,gtefield=RetryBackoffSeconds"`
+	RecrawlAfterDays           int    `yaml:"recrawlAfterDays" default:"30" validate:"required,min=1"`
+	PodcastListFile            string `yaml:"podcastListFile" default:"data/podcasts.txt" validate:"required"` // File of IDs or podcast urls to crawl, or - for stdin. May be gzip or zstd compressed
+	InputFormat                string `yaml:"inputFormat" default:"auto" validate:"required,oneof=auto text csv jsonl opml"`
+	InputIDField               string `yaml:"inputIdField"` // CSV column, JSON field or OPML attribute holding IDs. Found by name when empty
+	FailedListFile             string `yaml:"failedListFile" default:"data/failed.txt" validate:"required"`
+	ArchiveResponses           bool   `yaml:"archiveResponses" default:"true"`
+	ArchiveDir                 string `yaml:"archiveDir" default:"data/archive" validate:"required"`
+	RunSummaryFormat           string `yaml:"runSummaryFormat" default:"text" validate:"required,oneof=text json"`
+	LogDestination             string `yaml:"logDestination" default:"logs/" validate:"required"`
 }
 
 var AppConfig *Config
 
-func Load() error {
+// Resolves the config from, in increasing precedence, the embedded config,
+// the yaml file given with -config or PODCRAWLER_CONFIG, PODCRAWLER_*
+// environment variables and flags named after settings, like -saveTreshold
+// or -database.host. Flags end at the first argument that isn't one, and the
+// arguments from there on are returned
+func Load(args []string) ([]string, error) {
 	config := &Config{}
 
 	// Set defaults
 	if err := defaults.Set(config); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Unmarshal yaml
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return err
+	// Unmarshal embedded yaml
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	list := settings(config)
+	flags := flag.NewFlagSet("podcrawler", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv(envPrefix+"CONFIG"), "Yaml `file` overriding the embedded config")
+	overrides := make([]*flagOverride, len(list))
+	for i, s := range list {
+		overrides[i] = &flagOverride{setting: s}
+		flags.Var(overrides[i], s.path, "Overrides "+s.path+", also set by "+s.env())
+	}
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcrawler [flags] [command] [arguments]")
+		fmt.Fprintln(flags.Output(), "Run `podcrawler help` to list commands")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := loadFile(config, *path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadEnv(list); err != nil {
+		return nil, err
+	}
+
+	for _, override := range overrides {
+		if override.raw == nil {
+			continue
+		}
+		if err := override.setting.set(*override.raw); err != nil {
+			return nil, fmt.Errorf("invalid value %q for -%s: %w", *override.raw, override.setting.path, err)
+		}
 	}
 
 	// Validate
 	v = validator.New()
+	v.RegisterTagNameFunc(yamlKey)
 	if err := v.Struct(config); err != nil {
-		return err
+		return nil, fieldErrors(err)
 	}
 
 	AppConfig = config
-	return nil
+	return flags.Args(), nil
 }
@@ -0,0 +1,116 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Uses the embedded config without overrides", func(t *testing.T) {
+		args, err := config.Load(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(args) != 0 {
+			t.Errorf("Load() = %v, want no arguments", args)
+		}
+		if config.AppConfig.SaveTreshold != 50000 || config.AppConfig.Database.Backend != "postgres" {
+			t.Errorf("AppConfig = %+v, want the embedded config", config.AppConfig)
+		}
+	})
+
+	t.Run("Overrides the file with the environment and the environment with flags", func(t *testing.T) {
+		path := writeConfig(t, "saveTreshold: 10\nparseWorkers: 2\nconvertWorkers: 2\ndatabase:\n  backend: sqlite\n")
+		t.Setenv("PODCRAWLER_PARSE_WORKERS", "3")
+		t.Setenv("PODCRAWLER_CONVERT_WORKERS", "3")
+
+		_, err := config.Load([]string{"-config", path, "-convertWorkers", "4"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := config.AppConfig
+		if c.SaveTreshold != 10 || c.Database.Backend != "sqlite" {
+			t.Errorf("file settings = %d, %s, want 10, sqlite", c.SaveTreshold, c.Database.Backend)
+		}
+		if c.ParseWorkers != 3 {
+			t.Errorf("ParseWorkers = %d, want 3 from the environment", c.ParseWorkers)
+		}
+		if c.ConvertWorkers != 4 {
+			t.Errorf("ConvertWorkers = %d, want 4 from flags", c.ConvertWorkers)
+		}
+	})
+
+	t.Run("Reads the config path from the environment", func(t *testing.T) {
+		t.Setenv("PODCRAWLER_CONFIG", writeConfig(t, "userAgent: test-agent\n"))
+
+		if _, err := config.Load(nil); err != nil {
+			t.Fatal(err)
+		}
+		if config.AppConfig.UserAgent != "test-agent" {
+			t.Errorf("UserAgent = %s, want test-agent", config.AppConfig.UserAgent)
+		}
+	})
+
+	t.Run("Sets nested settings and bare boolean flags", func(t *testing.T) {
+		args, err := config.Load([]string{"-database.host", "db.internal", "-archiveResponses=false", "-adaptiveFetchIdsCount", "recrawl", "-days", "3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.AppConfig.Database.Host != "db.internal" {
+			t.Errorf("Database.Host = %s, want db.internal", config.AppConfig.Database.Host)
+		}
+		if config.AppConfig.ArchiveResponses || !config.AppConfig.AdaptiveFetchIDsCount {
+			t.Errorf("boolean flags were not applied")
+		}
+		if strings.Join(args, " ") != "recrawl -days 3" {
+			t.Errorf("Load() = %v, want the command and its arguments", args)
+		}
+	})
+
+	t.Run("Reports every invalid field", func(t *testing.T) {
+		path := writeConfig(t, "parseWorkers: 0\nrunSummaryFormat: xml\n")
+
+		_, err := config.Load([]string{"-config", path, "-retryBackoffMaxSeconds", "1"})
+		if err == nil {
+			t.Fatal("Load() succeeded, want an error")
+		}
+
+		for _, want := range []string{
+			"parseWorkers: must not be 0",
+			"runSummaryFormat: must be one of text, json",
+			"retryBackoffMaxSeconds: must be at least retryBackoffSeconds",
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Load() error = %q, want it to contain %q", err, want)
+			}
+		}
+	})
+
+	t.Run("Rejects unknown keys and malformed values", func(t *testing.T) {
+		if _, err := config.Load([]string{"-config", writeConfig(t, "saveTreshhold: 10\n")}); err == nil {
+			t.Error("Load() accepted an unknown key")
+		}
+
+		t.Setenv("PODCRAWLER_SAVE_TRESHOLD", "many")
+		if _, err := config.Load(nil); err == nil {
+			t.Error("Load() accepted a malformed environment variable")
+		}
+	})
+}
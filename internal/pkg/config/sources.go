@@ -0,0 +1,234 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Prefix of environment variables overriding settings
+const envPrefix = "PODCRAWLER_"
+
+// A setting that can be overridden, named by its path of yaml keys
+type setting struct {
+	path  string
+	value reflect.Value
+}
+
+// Environment variable overriding the setting, like PODCRAWLER_DATABASE_HOST
+// for database.host
+func (s setting) env() string {
+	var name strings.Builder
+	name.WriteString(envPrefix)
+
+	runes := []rune(s.path)
+	for i, r := range runes {
+		switch {
+		case r == '.':
+			name.WriteRune('_')
+		case unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])):
+			name.WriteRune('_')
+			name.WriteRune(r)
+		default:
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+
+	return name.String()
+}
+
+// Parses raw into the setting's value
+func (s setting) set(raw string) error {
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, s.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, s.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		s.value.SetUint(u)
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+
+	return nil
+}
+
+// Lists every setting of config, nested structs included
+func settings(config *Config) []setting {
+	return appendSettings(nil, "", reflect.ValueOf(config).Elem())
+}
+
+func appendSettings(list []setting, prefix string, value reflect.Value) []setting {
+	for i := 0; i < value.NumField(); i++ {
+		key := yamlKey(value.Type().Field(i))
+		if key == "" {
+			continue
+		}
+
+		field := value.Field(i)
+		if field.Kind() == reflect.Struct {
+			list = appendSettings(list, prefix+key+".", field)
+			continue
+		}
+		list = append(list, setting{path: prefix + key, value: field})
+	}
+
+	return list
+}
+
+func yamlKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if key == "-" {
+		return ""
+	}
+
+	return key
+}
+
+// Flag value recording a setting's override until flags are applied after
+// the config file and the environment
+type flagOverride struct {
+	setting setting
+	raw     *string
+}
+
+func (f *flagOverride) String() string {
+	if f.raw == nil {
+		return ""
+	}
+	return *f.raw
+}
+
+func (f *flagOverride) Set(raw string) error {
+	f.raw = &raw
+	return nil
+}
+
+// Lets boolean settings be enabled with a bare flag
+func (f *flagOverride) IsBoolFlag() bool {
+	return f.setting.value.Kind() == reflect.Bool
+}
+
+// Overlays the yaml file at path onto config. Keys that don't name a setting
+// are an error, so typos don't go unnoticed
+func loadFile(config *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Overrides settings with the PODCRAWLER_* environment variables that are set
+func loadEnv(list []setting) error {
+	errs := make([]error, 0)
+	for _, s := range list {
+		raw, ok := os.LookupEnv(s.env())
+		if !ok {
+			continue
+		}
+		if err := s.set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", raw, s.env(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Turns validation errors into one error per field, named by yaml path
+func fieldErrors(err error) error {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return err
+	}
+
+	errs := make([]error, len(validationErrs))
+	for i, fe := range validationErrs {
+		_, path, _ := strings.Cut(fe.Namespace(), ".")
+		errs[i] = fmt.Errorf("%s: %s", path, describe(fe))
+	}
+
+	return errors.Join(errs...)
+}
+
+func describe(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		switch fe.Kind() {
+		case reflect.String, reflect.Struct:
+			return "is required"
+		default:
+			return "must not be 0"
+		}
+	case "required_if":
+		field, value, _ := strings.Cut(fe.Param(), " ")
+		return fmt.Sprintf("is required when %s is %s", settingName(fe, field), value)
+	case "min":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", strings.Join(strings.Fields(fe.Param()), ", "))
+	case "gtefield":
+		return fmt.Sprintf("must be at least %s", settingName(fe, fe.Param()))
+	case "ltefield":
+		return fmt.Sprintf("must be at most %s", settingName(fe, fe.Param()))
+	case "excluded_with":
+		return fmt.Sprintf("can't be set along with %s", settingName(fe, fe.Param()))
+	default:
+		return fmt.Sprintf("failed the %s check", fe.Tag())
+	}
+}
+
+// Yaml path of the Go field named goName that sits next to fe's field
+func settingName(fe validator.FieldError, goName string) string {
+	parents := strings.Split(fe.StructNamespace(), ".")
+	parents = parents[1 : len(parents)-1]
+
+	t := reflect.TypeOf(Config{})
+	path := make([]string, 0, len(parents)+1)
+	for _, name := range parents {
+		field, ok := t.FieldByName(name)
+		if !ok {
+			return goName
+		}
+		path = append(path, yamlKey(field))
+		t = field.Type
+	}
+
+	field, ok := t.FieldByName(goName)
+	if !ok {
+		return goName
+	}
+	return strings.Join(append(path, yamlKey(field)), ".")
+}
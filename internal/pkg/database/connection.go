@@ -1,30 +1,96 @@
 package database
 
 import (
-	"gorm.io/driver/postgres"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	gormlogger "gorm.io/gorm/logger"
 )
 
-const dsn = "host=localhost user=postgres password=g7bXjBroyyCXyL92ZJT4mQu6hc3pTQAA port=5433 dbname=crawled-podcasts sslmode=disable"
+var (
+	store     Store
+	storeErr  error
+	storeOnce sync.Once
+)
 
-var db *gorm.DB
+// Returns the store of the configured backend, opening it on first use.
+// Safe for concurrent use. An open that failed isn't retried
+func GetStore() (Store, error) {
+	storeOnce.Do(func() {
+		var options Options
+		options, storeErr = configOptions(config.AppConfig)
+		if storeErr != nil {
+			return
+		}
+		store, storeErr = Open(options)
+	})
 
-func GetInstance() (*gorm.DB, error) {
-	if db != nil {
-		return db, nil
+	return store, storeErr
+}
+
+// Builds store options from the config. Postgres connects to database.url,
+// then DATABASE_URL, then the individual connection settings
+func configOptions(c *config.Config) (Options, error) {
+	options := Options{
+		Backend:  c.Database.Backend,
+		DSN:      c.Database.URL,
+		Password: c.Database.Password,
+		Path:     c.Database.Path,
+		Pool: PoolOptions{
+			MaxOpenConns:     c.Database.MaxOpenConns,
+			MaxIdleConns:     c.Database.MaxIdleConns,
+			ConnMaxLifetime:  seconds(c.Database.ConnMaxLifetimeSeconds),
+			ConnMaxIdleTime:  seconds(c.Database.ConnMaxIdleTimeSeconds),
+			ConnectTimeout:   seconds(c.Database.ConnectTimeoutSeconds),
+			StatementTimeout: seconds(c.Database.StatementTimeoutSeconds),
+		},
+		Writer:       c.PersistenceBackend,
+		SaveAttempts: c.SaveAttempts,
+		BackoffBase:  seconds(c.SaveBackoffSeconds),
+		BackoffMax:   seconds(c.SaveBackoffMaxSeconds),
+	}
+
+	if options.DSN == "" {
+		options.DSN = os.Getenv("DATABASE_URL")
+	}
+	if options.DSN == "" {
+		options.DSN = postgresDSN(c.Database.Host, c.Database.Port, c.Database.User, c.Database.DBName, c.Database.SSLMode)
 	}
 
-	var err error
-	c := &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Error),
-		// Logger: logger.Default.LogMode(logger.Silent),
+	if c.Database.PasswordFile != "" {
+		password, err := os.ReadFile(c.Database.PasswordFile)
+		if err != nil {
+			return options, fmt.Errorf("unable to read database password file: %w", err)
+		}
+		options.Password = strings.TrimRight(string(password), "\r\n")
 	}
 
-	db, err = gorm.Open(postgres.Open(dsn), c)
+	return options, nil
+}
+
+// Returns the GORM session of the configured store
+func GetInstance() (*gorm.DB, error) {
+	s, err := GetStore()
 	if err != nil {
-		db = nil
+		return nil, err
+	}
+
+	return s.DB(), nil
+}
+
+func gormConfig() *gorm.Config {
+	return &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Error),
+		// Logger: gormlogger.Default.LogMode(gormlogger.Silent),
 	}
+}
 
-	return db, err
+// Durations of config settings given in seconds
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
 }
@@ -0,0 +1,332 @@
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations of every backend, in a directory named after the backend
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var embedded embed.FS
+
+// Returned by Check when migrations are pending
+var ErrOutdated = errors.New("database schema is out of date")
+
+// Returned by Down when a migration it would revert drops crawled data and
+// reverting wasn't forced
+var ErrDestructive = errors.New("reverting would drop crawled data")
+
+// First line of a .down.sql file whose migration drops crawled data. Such
+// migrations are only reverted when forced
+const destructiveMarker = "-- destructive"
+
+// A numbered schema change, read from <version>_<name>.up.sql and the
+// matching .down.sql that reverts it
+type Migration struct {
+	Version     int64
+	Name        string
+	Up          string
+	Down        string
+	Destructive bool // Down drops crawled data
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// A migration and when it was applied. Migrations applied by a newer build
+// are listed with only their version and name
+type State struct {
+	Migration
+	AppliedAt *time.Time
+	Unknown   bool // Applied, but not one of this build's migrations
+}
+
+// Row of the schema_migrations table
+type appliedMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (appliedMigration) TableName() string {
+	return "schema_migrations"
+}
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamp NOT NULL
+)`
+
+// Returns the embedded migrations of backend, in version order
+func ForBackend(backend string) ([]Migration, error) {
+	dir, err := fs.Sub(embedded, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations for the %s backend", backend)
+	}
+
+	return migrations, nil
+}
+
+// Reads migrations from the .sql files at the root of fsys, in version order.
+// Every migration needs both an up and a down file
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration file %s isn't named <version>_<name>.up.sql or .down.sql", file)
+		}
+		number, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(number, 10, 64)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration file %s doesn't start with a version number", file)
+		}
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+			m.Destructive = strings.HasPrefix(strings.TrimSpace(m.Down), destructiveMarker)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %s needs both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Applies and reverts migrations, recording them in schema_migrations
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func New(db *gorm.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Applies every migration that hasn't been applied yet, each in its own
+// transaction. Returns the migrations applied before any failure
+func (m *Migrator) Up() ([]Migration, error) {
+	if err := m.db.Exec(createTable).Error; err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]Migration, 0)
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&appliedMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %s failed: %w", migration, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Reverts the steps most recently applied migrations, newest first. Fails
+// with ErrDestructive before reverting anything when one of them drops
+// crawled data, unless force is set. Returns the migrations reverted before
+// any failure
+func (m *Migrator) Down(steps int, force bool) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("can't revert %d migrations", steps)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	known := m.byVersion()
+	for _, version := range versions {
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %04d_%s was applied by a newer build and can't be reverted by this one", version, applied[version].Name)
+		}
+		if migration.Destructive && !force {
+			return nil, fmt.Errorf("%w: migration %s drops tables holding crawled data", ErrDestructive, migration)
+		}
+	}
+
+	done := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		migration := known[version]
+
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&appliedMigration{Version: version}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("reverting migration %s failed: %w", migration, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Lists every migration of this build along with any applied by a newer
+// one, in version order
+func (m *Migrator) Status() ([]State, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]State, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		state := State{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+
+	known := m.byVersion()
+	for version, row := range applied {
+		if _, ok := known[version]; ok {
+			continue
+		}
+		appliedAt := row.AppliedAt
+		states = append(states, State{
+			Migration: Migration{Version: version, Name: row.Name},
+			AppliedAt: &appliedAt,
+			Unknown:   true,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Version < states[j].Version
+	})
+
+	return states, nil
+}
+
+// Fails with ErrOutdated when migrations are pending. Also fails when the
+// database was migrated by a newer build
+func (m *Migrator) Check() error {
+	states, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	pending := make([]string, 0)
+	for _, state := range states {
+		if state.Unknown {
+			return fmt.Errorf("database schema is newer than this build: migration %s isn't known", state.Migration)
+		}
+		if state.AppliedAt == nil {
+			pending = append(pending, state.Migration.String())
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending migrations (%s)", ErrOutdated, len(pending), strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+// Applied migrations by version. None are applied to a database without a
+// schema_migrations table
+func (m *Migrator) applied() (map[int64]appliedMigration, error) {
+	if !m.db.Migrator().HasTable(&appliedMigration{}) {
+		return map[int64]appliedMigration{}, nil
+	}
+
+	var rows []appliedMigration
+	if err := m.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) byVersion() map[int64]Migration {
+	migrations := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		migrations[migration.Version] = migration
+	}
+
+	return migrations
+}
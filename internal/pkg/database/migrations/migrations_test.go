@@ -0,0 +1,225 @@
+package migrations_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/migrations"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "migrations.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+var testMigrations = fstest.MapFS{
+	"0001_shows.up.sql":      {Data: []byte("CREATE TABLE shows (id integer PRIMARY KEY);")},
+	"0001_shows.down.sql":    {Data: []byte("DROP TABLE shows;")},
+	"0002_episodes.up.sql":   {Data: []byte("CREATE TABLE episodes (id integer PRIMARY KEY); CREATE INDEX idx_episodes ON episodes (id);")},
+	"0002_episodes.down.sql": {Data: []byte("DROP TABLE episodes;")},
+}
+
+func loadTestMigrations(t *testing.T) []migrations.Migration {
+	t.Helper()
+
+	list, err := migrations.Load(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return list
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Reads migrations in version order", func(t *testing.T) {
+		list := loadTestMigrations(t)
+		if len(list) != 2 || list[0].String() != "0001_shows" || list[1].String() != "0002_episodes" {
+			t.Errorf("Load() = %v, want 0001_shows and 0002_episodes", list)
+		}
+	})
+
+	tests := []struct {
+		title string
+		files fstest.MapFS
+	}{
+		{
+			title: "Refuses a migration without a down file",
+			files: fstest.MapFS{"0001_shows.up.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			title: "Refuses files without a version",
+			files: fstest.MapFS{"shows.up.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			title: "Refuses files without a direction",
+			files: fstest.MapFS{"0001_shows.sql": {Data: []byte("SELECT 1;")}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if _, err := migrations.Load(test.files); err == nil {
+				t.Error("Load() succeeded, want an error")
+			}
+		})
+	}
+
+	t.Run("Embeds migrations for every backend", func(t *testing.T) {
+		for _, backend := range []string{"postgres", "sqlite"} {
+			list, err := migrations.ForBackend(backend)
+			if err != nil {
+				t.Errorf("ForBackend(%q) error = %v", backend, err)
+				continue
+			}
+			if !list[0].Destructive {
+				t.Errorf("ForBackend(%q) baseline migration isn't marked destructive", backend)
+			}
+		}
+	})
+}
+
+func TestMigrator(t *testing.T) {
+	t.Run("Applies pending migrations once", func(t *testing.T) {
+		db := openDB(t)
+		migrator := migrations.New(db, loadTestMigrations(t))
+
+		if err := migrator.Check(); !errors.Is(err, migrations.ErrOutdated) {
+			t.Errorf("Check() before migrating = %v, want ErrOutdated", err)
+		}
+
+		applied, err := migrator.Up()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != 2 {
+			t.Errorf("Up() applied %d migrations, want 2", len(applied))
+		}
+		if !db.Migrator().HasTable("episodes") {
+			t.Error("Up() didn't run every statement of a migration")
+		}
+
+		applied, err = migrator.Up()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != 0 {
+			t.Errorf("second Up() applied %d migrations, want 0", len(applied))
+		}
+		if err := migrator.Check(); err != nil {
+			t.Errorf("Check() after migrating = %v", err)
+		}
+	})
+
+	t.Run("Reverts the latest migrations", func(t *testing.T) {
+		db := openDB(t)
+		migrator := migrations.New(db, loadTestMigrations(t))
+		if _, err := migrator.Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		reverted, err := migrator.Down(1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reverted) != 1 || reverted[0].Version != 2 {
+			t.Errorf("Down(1) = %v, want 0002_episodes", reverted)
+		}
+		if db.Migrator().HasTable("episodes") || !db.Migrator().HasTable("shows") {
+			t.Error("Down(1) didn't revert only the latest migration")
+		}
+
+		states, err := migrator.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(states) != 2 || states[0].AppliedAt == nil || states[1].AppliedAt != nil {
+			t.Errorf("Status() = %+v, want only 0001_shows applied", states)
+		}
+	})
+
+	t.Run("Reverts a migration dropping data only when forced", func(t *testing.T) {
+		db := openDB(t)
+		files := fstest.MapFS{
+			"0001_shows.up.sql":     {Data: []byte("CREATE TABLE shows (id integer PRIMARY KEY);")},
+			"0001_shows.down.sql":   {Data: []byte("-- destructive: drops saved shows\nDROP TABLE shows;")},
+			"0002_ratings.up.sql":   {Data: []byte("CREATE TABLE ratings (id integer PRIMARY KEY);")},
+			"0002_ratings.down.sql": {Data: []byte("DROP TABLE ratings;")},
+		}
+		list, err := migrations.Load(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !list[0].Destructive || list[1].Destructive {
+			t.Fatalf("Load() = %+v, want only 0001_shows destructive", list)
+		}
+
+		migrator := migrations.New(db, list)
+		if _, err := migrator.Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		reverted, err := migrator.Down(2, false)
+		if !errors.Is(err, migrations.ErrDestructive) {
+			t.Errorf("Down(2, false) error = %v, want ErrDestructive", err)
+		}
+		if len(reverted) != 0 || !db.Migrator().HasTable("ratings") || !db.Migrator().HasTable("shows") {
+			t.Error("Down(2, false) reverted migrations before refusing")
+		}
+
+		reverted, err = migrator.Down(2, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reverted) != 2 || db.Migrator().HasTable("shows") {
+			t.Errorf("Down(2, true) = %v, want both migrations reverted", reverted)
+		}
+	})
+
+	t.Run("Rolls back a failed migration", func(t *testing.T) {
+		db := openDB(t)
+		files := fstest.MapFS{
+			"0001_broken.up.sql":   {Data: []byte("CREATE TABLE shows (id integer PRIMARY KEY); SELECT * FROM missing;")},
+			"0001_broken.down.sql": {Data: []byte("DROP TABLE shows;")},
+		}
+		list, err := migrations.Load(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := migrations.New(db, list).Up(); err == nil {
+			t.Fatal("Up() succeeded, want an error")
+		}
+		if db.Migrator().HasTable("shows") {
+			t.Error("Up() kept part of a failed migration")
+		}
+	})
+
+	t.Run("Refuses a schema migrated by a newer build", func(t *testing.T) {
+		db := openDB(t)
+		if _, err := migrations.New(db, loadTestMigrations(t)).Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		older := migrations.New(db, loadTestMigrations(t)[:1])
+		err := older.Check()
+		if err == nil || errors.Is(err, migrations.ErrOutdated) {
+			t.Errorf("Check() = %v, want an error about a newer schema", err)
+		}
+		if _, err := older.Down(1, false); err == nil {
+			t.Error("Down() reverted a migration it doesn't know")
+		}
+	})
+}
@@ -0,0 +1,122 @@
+-- Schema of the crawler before migrations were versioned. Every statement is
+-- conditional so databases created by earlier builds are adopted as they are
+
+CREATE TABLE IF NOT EXISTS genres (
+	id uuid PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz DEFAULT NULL,
+	deleted_at timestamptz DEFAULT NULL,
+	name text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_genres_name ON genres USING btree (name);
+CREATE INDEX IF NOT EXISTS idx_genres_deleted_at ON genres (deleted_at);
+
+CREATE TABLE IF NOT EXISTS podcasts (
+	id uuid PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz DEFAULT NULL,
+	deleted_at timestamptz DEFAULT NULL,
+	title text NOT NULL,
+	censored_title text NOT NULL,
+	feed_url text,
+	artist_name text,
+	release_date text,
+	description text,
+	country text,
+	episode_count bigint,
+	content_advisory_rating text,
+	itunes_id bigint,
+	itunes_view_url text,
+	itunes_artwork_url30 text,
+	itunes_artwork_url60 text,
+	itunes_artwork_url100 text,
+	itunes_artwork_url600 text,
+	itunes_artist_id bigint DEFAULT NULL,
+	itunes_artist_view_url text DEFAULT NULL,
+	primary_genre_id uuid,
+	crawl_run_id uuid,
+	raw jsonb,
+	CONSTRAINT fk_podcasts_primary_genre FOREIGN KEY (primary_genre_id) REFERENCES genres (id)
+);
+ALTER TABLE podcasts ADD COLUMN IF NOT EXISTS crawl_run_id uuid;
+ALTER TABLE podcasts ADD COLUMN IF NOT EXISTS raw jsonb;
+
+CREATE TABLE IF NOT EXISTS podcast_genres (
+	podcast_id uuid,
+	genre_id uuid,
+	PRIMARY KEY (podcast_id, genre_id),
+	CONSTRAINT fk_podcast_genres_genre FOREIGN KEY (genre_id) REFERENCES genres (id),
+	CONSTRAINT fk_podcasts_podcast_genres FOREIGN KEY (podcast_id) REFERENCES podcasts (id)
+);
+
+-- Podcasts saved before iTunes IDs were unique can share one. Live rows are
+-- kept over soft deleted ones, then the most recently updated
+CREATE TEMPORARY TABLE duplicate_podcasts ON COMMIT DROP AS
+SELECT id FROM (
+	SELECT id, ROW_NUMBER() OVER (
+		PARTITION BY itunes_id
+		ORDER BY deleted_at IS NULL DESC, updated_at DESC NULLS LAST, created_at DESC
+	) AS position
+	FROM podcasts
+	WHERE itunes_id IS NOT NULL
+) ranked
+WHERE position > 1;
+DELETE FROM podcast_genres WHERE podcast_id IN (SELECT id FROM duplicate_podcasts);
+DELETE FROM podcasts WHERE id IN (SELECT id FROM duplicate_podcasts);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_podcasts_itunes_id ON podcasts (itunes_id);
+CREATE INDEX IF NOT EXISTS idx_podcasts_title ON podcasts USING btree (title);
+CREATE INDEX IF NOT EXISTS idx_podcasts_censored_title ON podcasts USING btree (censored_title);
+CREATE INDEX IF NOT EXISTS idx_podcasts_crawl_run_id ON podcasts USING btree (crawl_run_id);
+CREATE INDEX IF NOT EXISTS idx_podcasts_raw ON podcasts USING gin (raw);
+CREATE INDEX IF NOT EXISTS idx_podcasts_deleted_at ON podcasts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS crawl_queue (
+	itunes_id bigint PRIMARY KEY,
+	state text NOT NULL DEFAULT 'pending',
+	attempts bigint NOT NULL DEFAULT 0,
+	last_attempt_at timestamptz DEFAULT NULL,
+	next_attempt_at timestamptz DEFAULT NULL,
+	created_at timestamptz,
+	updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_crawl_queue_state ON crawl_queue USING btree (state);
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+	itunes_id bigint PRIMARY KEY,
+	category text NOT NULL,
+	http_status bigint NOT NULL DEFAULT 0,
+	attempts bigint NOT NULL DEFAULT 0,
+	first_failed_at timestamptz NOT NULL,
+	last_failed_at timestamptz NOT NULL,
+	crawl_run_id uuid
+);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_category ON dead_letters USING btree (category);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_crawl_run_id ON dead_letters USING btree (crawl_run_id);
+
+CREATE TABLE IF NOT EXISTS podcast_revisions (
+	id bigserial PRIMARY KEY,
+	itunes_id bigint NOT NULL,
+	run_id uuid NOT NULL,
+	field text NOT NULL,
+	old_value text,
+	new_value text,
+	changed_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_podcast_revisions_itunes_id ON podcast_revisions USING btree (itunes_id);
+CREATE INDEX IF NOT EXISTS idx_podcast_revisions_run_id ON podcast_revisions USING btree (run_id);
+
+CREATE TABLE IF NOT EXISTS crawl_runs (
+	id uuid PRIMARY KEY,
+	mode text NOT NULL,
+	input_file text DEFAULT NULL,
+	status text NOT NULL,
+	id_count bigint NOT NULL DEFAULT 0,
+	saved bigint NOT NULL DEFAULT 0,
+	failed bigint NOT NULL DEFAULT 0,
+	requeued bigint NOT NULL DEFAULT 0,
+	started_at timestamptz NOT NULL,
+	finished_at timestamptz DEFAULT NULL,
+	updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_crawl_runs_status ON crawl_runs USING btree (status);
@@ -0,0 +1,8 @@
+-- destructive: drops every table, including crawled podcasts and genres
+DROP TABLE IF EXISTS crawl_runs;
+DROP TABLE IF EXISTS podcast_revisions;
+DROP TABLE IF EXISTS dead_letters;
+DROP TABLE IF EXISTS crawl_queue;
+DROP TABLE IF EXISTS podcast_genres;
+DROP TABLE IF EXISTS podcasts;
+DROP TABLE IF EXISTS genres;
@@ -0,0 +1,105 @@
+-- Schema of the crawler before migrations were versioned. Tables and indexes
+-- are created only if missing so databases created by earlier builds are
+-- adopted as they are. SQLite only has B-tree indexes, so the GIN index on
+-- podcasts.raw is left out
+
+CREATE TABLE IF NOT EXISTS genres (
+	id uuid PRIMARY KEY,
+	created_at datetime,
+	updated_at datetime DEFAULT NULL,
+	deleted_at datetime DEFAULT NULL,
+	name text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_genres_name ON genres (name);
+CREATE INDEX IF NOT EXISTS idx_genres_deleted_at ON genres (deleted_at);
+
+CREATE TABLE IF NOT EXISTS podcasts (
+	id uuid PRIMARY KEY,
+	created_at datetime,
+	updated_at datetime DEFAULT NULL,
+	deleted_at datetime DEFAULT NULL,
+	title text NOT NULL,
+	censored_title text NOT NULL,
+	feed_url text,
+	artist_name text,
+	release_date text,
+	description text,
+	country text,
+	episode_count integer,
+	content_advisory_rating text,
+	itunes_id integer,
+	itunes_view_url text,
+	itunes_artwork_url30 text,
+	itunes_artwork_url60 text,
+	itunes_artwork_url100 text,
+	itunes_artwork_url600 text,
+	itunes_artist_id integer DEFAULT NULL,
+	itunes_artist_view_url text DEFAULT NULL,
+	primary_genre_id uuid,
+	crawl_run_id uuid,
+	raw json,
+	CONSTRAINT fk_podcasts_primary_genre FOREIGN KEY (primary_genre_id) REFERENCES genres (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_podcasts_itunes_id ON podcasts (itunes_id);
+CREATE INDEX IF NOT EXISTS idx_podcasts_title ON podcasts (title);
+CREATE INDEX IF NOT EXISTS idx_podcasts_censored_title ON podcasts (censored_title);
+CREATE INDEX IF NOT EXISTS idx_podcasts_crawl_run_id ON podcasts (crawl_run_id);
+CREATE INDEX IF NOT EXISTS idx_podcasts_deleted_at ON podcasts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS podcast_genres (
+	podcast_id uuid,
+	genre_id uuid,
+	PRIMARY KEY (podcast_id, genre_id),
+	CONSTRAINT fk_podcast_genres_genre FOREIGN KEY (genre_id) REFERENCES genres (id),
+	CONSTRAINT fk_podcasts_podcast_genres FOREIGN KEY (podcast_id) REFERENCES podcasts (id)
+);
+
+CREATE TABLE IF NOT EXISTS crawl_queue (
+	itunes_id integer PRIMARY KEY,
+	state text NOT NULL DEFAULT 'pending',
+	attempts integer NOT NULL DEFAULT 0,
+	last_attempt_at datetime DEFAULT NULL,
+	next_attempt_at datetime DEFAULT NULL,
+	created_at datetime,
+	updated_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_crawl_queue_state ON crawl_queue (state);
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+	itunes_id integer PRIMARY KEY,
+	category text NOT NULL,
+	http_status integer NOT NULL DEFAULT 0,
+	attempts integer NOT NULL DEFAULT 0,
+	first_failed_at datetime NOT NULL,
+	last_failed_at datetime NOT NULL,
+	crawl_run_id uuid
+);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_category ON dead_letters (category);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_crawl_run_id ON dead_letters (crawl_run_id);
+
+CREATE TABLE IF NOT EXISTS podcast_revisions (
+	id integer PRIMARY KEY,
+	itunes_id integer NOT NULL,
+	run_id uuid NOT NULL,
+	field text NOT NULL,
+	old_value text,
+	new_value text,
+	changed_at datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_podcast_revisions_itunes_id ON podcast_revisions (itunes_id);
+CREATE INDEX IF NOT EXISTS idx_podcast_revisions_run_id ON podcast_revisions (run_id);
+
+CREATE TABLE IF NOT EXISTS crawl_runs (
+	id uuid PRIMARY KEY,
+	mode text NOT NULL,
+	input_file text DEFAULT NULL,
+	status text NOT NULL,
+	id_count integer NOT NULL DEFAULT 0,
+	saved integer NOT NULL DEFAULT 0,
+	failed integer NOT NULL DEFAULT 0,
+	requeued integer NOT NULL DEFAULT 0,
+	started_at datetime NOT NULL,
+	finished_at datetime DEFAULT NULL,
+	updated_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_crawl_runs_status ON crawl_runs (status);
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+type QueueState string
+
+const (
+	QueuePending  QueueState = "pending"
+	QueueInFlight QueueState = "in_flight"
+	QueueDone     QueueState = "done"
+	QueueFailed   QueueState = "failed"
+)
+
+// A single iTunes ID tracked through the crawl. Rows are keyed on the iTunes
+// ID so re-enqueueing an ID that is already known is a no-op
+type CrawlQueueItem struct {
+	ItunesID      uint64     `gorm:"primaryKey;autoIncrement:false"`
+	State         QueueState `gorm:"not null;default:pending;index:,type:btree"`
+	Attempts      uint32     `gorm:"not null;default:0"`
+	LastAttemptAt *time.Time `gorm:"default:null"`
+	NextAttemptAt *time.Time `gorm:"default:null"` // Earliest time a requeued ID may be looked up again
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (CrawlQueueItem) TableName() string {
+	return "crawl_queue"
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+type RunMode string
+
+const (
+	RunCrawl     RunMode = "crawl"     // IDs from the input file
+	RunRecrawl   RunMode = "recrawl"   // Saved podcasts refreshed by the recrawl command
+	RunReprocess RunMode = "reprocess" // Archived responses saved again by the reprocess command
+)
+
+type RunStatus string
+
+const (
+	RunRunning     RunStatus = "running"
+	RunCompleted   RunStatus = "completed"
+	RunIncomplete  RunStatus = "incomplete" // Finished with some IDs failing
+	RunInterrupted RunStatus = "interrupted"
+	RunFailed      RunStatus = "failed"
+)
+
+// A single invocation of the crawler. Counters are updated as results are
+// saved and once more when the run finishes
+type CrawlRun struct {
+	ID         string     `gorm:"primaryKey;type:uuid"`
+	Mode       RunMode    `gorm:"not null"`
+	InputFile  *string    `gorm:"default:null"`
+	Status     RunStatus  `gorm:"not null;index:,type:btree"`
+	IDCount    int64      `gorm:"not null;default:0"` // Pending IDs when the run started
+	Saved      int64      `gorm:"not null;default:0"`
+	Failed     int64      `gorm:"not null;default:0"`
+	Requeued   int64      `gorm:"not null;default:0"` // Lookups scheduled for a retry
+	StartedAt  time.Time  `gorm:"not null"`
+	FinishedAt *time.Time `gorm:"default:null"`
+	UpdatedAt  time.Time
+}
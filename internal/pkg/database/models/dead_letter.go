@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type FailureCategory string
+
+const (
+	FailureMalformedBody       FailureCategory = "malformed_body"
+	FailureEmptyCollectionName FailureCategory = "empty_collection_name"
+	FailureUnavailable         FailureCategory = "unavailable" // Missing from a lookup of the ID on its own
+	FailureTransportError      FailureCategory = "transport_error"
+	FailurePersistError        FailureCategory = "persist_error" // Results that couldn't be saved
+	FailureRejected            FailureCategory = "rejected"      // Results the database refused to save
+)
+
+// An iTunes ID that could not be crawled, along with the reason for its most
+// recent failure
+type DeadLetter struct {
+	ItunesID      uint64          `gorm:"primaryKey;autoIncrement:false"`
+	Category      FailureCategory `gorm:"not null;index:,type:btree"`
+	HttpStatus    int             `gorm:"not null;default:0"`
+	Attempts      uint32          `gorm:"not null;default:0"`
+	FirstFailedAt time.Time       `gorm:"not null"`
+	LastFailedAt  time.Time       `gorm:"not null"`
+	CrawlRunID    *string         `gorm:"type:uuid;index:,type:btree"` // Run of the most recent failure
+}
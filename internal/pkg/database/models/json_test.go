@@ -0,0 +1,72 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+)
+
+func TestJSON(t *testing.T) {
+	t.Run("Stores empty documents as NULL", func(t *testing.T) {
+		value, err := models.JSON(nil).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != nil {
+			t.Errorf("Value() = %v, want nil", value)
+		}
+	})
+
+	tests := []struct {
+		title string
+		value interface{}
+		want  string
+	}{
+		{
+			title: "Scans bytes",
+			value: []byte(`{"kind":"podcast"}`),
+			want:  `{"kind":"podcast"}`,
+		},
+		{
+			title: "Scans strings",
+			value: `{"kind":"podcast"}`,
+			want:  `{"kind":"podcast"}`,
+		},
+		{
+			title: "Scans NULL as an empty document",
+			value: nil,
+			want:  "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			j := models.JSON(`{"stale":true}`)
+			if err := j.Scan(test.value); err != nil {
+				t.Fatal(err)
+			}
+			if string(j) != test.want {
+				t.Errorf("Scan(%v) = %s, want %s", test.value, j, test.want)
+			}
+		})
+	}
+
+	t.Run("Copies scanned bytes", func(t *testing.T) {
+		b := []byte(`{"kind":"podcast"}`)
+		var j models.JSON
+		if err := j.Scan(b); err != nil {
+			t.Fatal(err)
+		}
+		b[2] = 'X'
+		if string(j) != `{"kind":"podcast"}` {
+			t.Errorf("scanned document changed with its source: %s", j)
+		}
+	})
+
+	t.Run("Rejects other types", func(t *testing.T) {
+		var j models.JSON
+		if err := j.Scan(42); err == nil {
+			t.Error("Scan(42) succeeded, want an error")
+		}
+	})
+}
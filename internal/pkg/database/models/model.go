@@ -3,12 +3,23 @@ package models
 import (
 	"time"
 
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
 	"gorm.io/gorm"
 )
 
 type Model struct {
 	gorm.Model
-	ID        string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	ID        string         `gorm:"primaryKey;type:uuid"`
 	UpdatedAt time.Time      `gorm:"default:null"`
 	DeletedAt gorm.DeletedAt `gorm:"index;default:null"`
 }
+
+// IDs are generated here rather than by the database, since not every
+// backend can generate UUIDs
+func (m *Model) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == "" {
+		m.ID = utils.NewUUID()
+	}
+
+	return nil
+}
@@ -14,7 +14,7 @@ type Podcast struct {
 	EpisodeCount          *uint32
 	ContentAdvisoryRating *string
 
-	ItunesID            *uint32 // `gorm:"unique"`
+	ItunesID            *uint32 `gorm:"uniqueIndex"`
 	ItunesViewUrl       *string // `gorm:"unique"`
 	ItunesArtworkUrl30  *string
 	ItunesArtworkUrl60  *string
@@ -25,7 +25,14 @@ type Podcast struct {
 	ItunesArtistViewUrl *string `gorm:"default:null"`
 
 	PrimaryGenreID *string
+	CrawlRunID     *string `gorm:"type:uuid;index:,type:btree"` // Run that last saved the podcast
+
+	Raw JSON `gorm:"index:,type:gin"` // Full lookup result, including fields that aren't modelled
 
 	PrimaryGenre  *Genre `gorm:"foreignKey:PrimaryGenreID"`
 	PodcastGenres []PodcastGenre
+
+	// Genres named by the lookup result, linked to genre rows on save
+	GenreNames       []string `gorm:"-"`
+	PrimaryGenreName *string  `gorm:"-"`
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// A change to one field of a saved podcast, recorded when a crawl finds a
+// value different from the saved one. Values are stored as text, with NULL
+// for missing values
+type PodcastRevision struct {
+	ID        uint64 `gorm:"primaryKey"`
+	ItunesID  uint64 `gorm:"not null;index:,type:btree"`
+	RunID     string `gorm:"type:uuid;not null;index:,type:btree"`
+	Field     string `gorm:"not null"`
+	OldValue  *string
+	NewValue  *string
+	ChangedAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type postgresStore struct {
+	*gormStore
+}
+
+// Opens a pool on options.DSN and checks that the database can be reached
+func openPostgres(options Options) (Store, error) {
+	connConfig, err := pgx.ParseConfig(options.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Postgres connection settings: %w", err)
+	}
+	if options.Password != "" {
+		connConfig.Password = options.Password
+	}
+	if options.Pool.ConnectTimeout > 0 {
+		connConfig.ConnectTimeout = options.Pool.ConnectTimeout
+	}
+	if options.Pool.StatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(options.Pool.StatementTimeout.Milliseconds(), 10)
+	}
+
+	sqlDB := stdlib.OpenDB(*connConfig)
+	sqlDB.SetMaxOpenConns(options.Pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(options.Pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(options.Pool.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(options.Pool.ConnMaxIdleTime)
+
+	ctx := context.Background()
+	if options.Pool.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Pool.ConnectTimeout)
+		defer cancel()
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, connectError(connConfig, err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), gormConfig())
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	s, err := newGormStore(BackendPostgres, db, options)
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	return &postgresStore{s}, nil
+}
+
+// Explains why the connectivity check failed, naming the server and user
+// but never the password
+func connectError(connConfig *pgx.ConnConfig, err error) error {
+	target := fmt.Sprintf("Postgres at %s:%d as %s", connConfig.Host, connConfig.Port, connConfig.User)
+
+	var pgErr *pgconn.PgError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &pgErr) && pgErr.Code == "28P01":
+		return fmt.Errorf("unable to connect to %s: wrong password: %w", target, err)
+	case errors.As(err, &pgErr) && pgErr.Code == "28000":
+		return fmt.Errorf("unable to connect to %s: the server refused the login: %w", target, err)
+	case errors.As(err, &pgErr) && pgErr.Code == "3D000":
+		return fmt.Errorf("unable to connect to %s: database `%s` doesn't exist: %w", target, connConfig.Database, err)
+	case errors.Is(err, context.DeadlineExceeded), pgconn.Timeout(err):
+		return fmt.Errorf("unable to connect to %s: no answer within the connect timeout: %w", target, err)
+	case errors.As(err, &netErr):
+		return fmt.Errorf("unable to connect to %s: is the server running and reachable? %w", target, err)
+	default:
+		return fmt.Errorf("unable to connect to %s: %w", target, err)
+	}
+}
+
+// Builds a keyword/value connection string, quoting values as libpq expects.
+// The password is left out and passed to the driver on its own
+func postgresDSN(host string, port uint16, user string, dbName string, sslMode string) string {
+	settings := []struct {
+		key   string
+		value string
+	}{
+		{"host", host},
+		{"port", strconv.Itoa(int(port))},
+		{"user", user},
+		{"dbname", dbName},
+		{"sslmode", sslMode},
+	}
+
+	pairs := make([]string, 0, len(settings))
+	for _, setting := range settings {
+		if setting.value == "" {
+			continue
+		}
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(setting.value)
+		pairs = append(pairs, fmt.Sprintf("%s='%s'", setting.key, value))
+	}
+
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,53 @@
+package database_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+)
+
+func TestOpenPostgres(t *testing.T) {
+	tests := []struct {
+		title string
+		dsn   string
+		want  string
+	}{
+		{
+			title: "Reports unreachable servers",
+			dsn:   "host=127.0.0.1 port=1 user=crawler dbname=podcasts sslmode=disable",
+			want:  "unable to connect to Postgres at 127.0.0.1:1 as crawler",
+		},
+		{
+			title: "Reports malformed connection settings",
+			dsn:   "postgres://crawler@127.0.0.1:port/podcasts",
+			want:  "invalid Postgres connection settings",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			_, err := database.Open(database.Options{
+				Backend:  database.BackendPostgres,
+				DSN:      test.dsn,
+				Password: "secret-password",
+				Pool: database.PoolOptions{
+					MaxOpenConns:   1,
+					ConnectTimeout: 2 * time.Second,
+				},
+				Writer: service.BackendGorm,
+			})
+			if err == nil {
+				t.Fatal("Open() succeeded, want an error")
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Open() error = %q, want it to contain %q", err, test.want)
+			}
+			if strings.Contains(err.Error(), "secret-password") {
+				t.Errorf("Open() error = %q, leaks the password", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Staging tables live for the whole session and are emptied on commit or
+// rollback, so every batch starts with empty ones
+const (
+	stagingPodcastsTable      = "staging_podcasts"
+	stagingPodcastGenresTable = "staging_podcast_genres"
+
+	createStagingTables = `
+CREATE TEMP TABLE IF NOT EXISTS staging_podcasts (LIKE podcasts INCLUDING DEFAULTS) ON COMMIT DELETE ROWS;
+CREATE TEMP TABLE IF NOT EXISTS staging_podcast_genres (
+	itunes_id bigint NOT NULL,
+	genre_id uuid NOT NULL
+) ON COMMIT DELETE ROWS;`
+
+	replaceStagedGenres = `
+DELETE FROM podcast_genres
+USING podcasts
+WHERE podcast_genres.podcast_id = podcasts.id
+	AND podcasts.itunes_id IN (SELECT itunes_id FROM staging_podcasts);
+
+INSERT INTO podcast_genres (podcast_id, genre_id)
+SELECT DISTINCT podcasts.id, staging_podcast_genres.genre_id
+FROM staging_podcast_genres
+JOIN podcasts ON podcasts.itunes_id = staging_podcast_genres.itunes_id
+ON CONFLICT DO NOTHING;`
+)
+
+// Podcast columns written by COPY
+var podcastCopyColumns = append([]string{"id", "created_at", "itunes_id"}, podcastUpsertColumns...)
+
+// Saves podcasts like UpsertPodcasts, but streams them into staging tables
+// with COPY and merges them with one statement per table. tx must have been
+// begun on conn. Genre links of podcasts without an iTunes ID aren't saved
+// since staged links are matched to podcasts by iTunes ID
+func CopyPodcasts(conn *gorm.DB, tx *gorm.DB, podcasts []models.Podcast) (int64, error) {
+	sqlConn, ok := conn.Statement.ConnPool.(*sql.Conn)
+	if !ok {
+		return 0, errors.New("COPY needs a session pinned to a single connection")
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(&models.Podcast{}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Exec(createStagingTables).Error; err != nil {
+		return 0, err
+	}
+
+	podcasts = uniquePodcasts(podcasts)
+	now := time.Now()
+	ctx := context.Background()
+	podcastRows := make([][]interface{}, len(podcasts))
+	genreRows := make([][]interface{}, 0, len(podcasts))
+	for i, p := range podcasts {
+		if p.ID == "" {
+			p.ID = utils.NewUUID()
+		}
+		p.CreatedAt = now
+		p.UpdatedAt = now
+
+		value := reflect.ValueOf(p)
+		row := make([]interface{}, len(podcastCopyColumns))
+		for j, column := range podcastCopyColumns {
+			field := stmt.Schema.LookUpField(column)
+			v, _ := field.ValueOf(ctx, value)
+			v, err := copyValue(field.DataType, v)
+			if err != nil {
+				return 0, fmt.Errorf("unable to copy %s of podcast %v: %w", column, indirect(p.ItunesID), err)
+			}
+			row[j] = v
+		}
+		podcastRows[i] = row
+
+		if p.ItunesID == nil {
+			continue
+		}
+		for _, genre := range p.PodcastGenres {
+			genreId, err := copyValue("uuid", genre.GenreID)
+			if err != nil {
+				return 0, err
+			}
+			genreRows = append(genreRows, []interface{}{int64(*p.ItunesID), genreId})
+		}
+	}
+
+	// Runs on the transaction's connection, so the copied rows are part of it
+	err := sqlConn.Raw(func(driverConn interface{}) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+
+		_, err := pgxConn.CopyFrom(ctx, pgx.Identifier{stagingPodcastsTable}, podcastCopyColumns, pgx.CopyFromRows(podcastRows))
+		if err != nil {
+			return err
+		}
+
+		_, err = pgxConn.CopyFrom(ctx, pgx.Identifier{stagingPodcastGenresTable}, []string{"itunes_id", "genre_id"}, pgx.CopyFromRows(genreRows))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	result := tx.Exec(mergeStagedPodcasts())
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	if err := tx.Exec(replaceStagedGenres).Error; err != nil {
+		return result.RowsAffected, err
+	}
+
+	return result.RowsAffected, nil
+}
+
+// Converts a model value into one pgx can encode in COPY's binary format,
+// which has no conversions from text for UUIDs
+func copyValue(dataType schema.DataType, value interface{}) (interface{}, error) {
+	value = indirect(value)
+	if valuer, ok := value.(driver.Valuer); ok {
+		return valuer.Value()
+	}
+
+	if s, ok := value.(string); ok && dataType == "uuid" {
+		var id pgtype.UUID
+		err := id.Scan(s)
+		return id, err
+	}
+
+	return value, nil
+}
+
+// Inserts staged podcasts, updating the existing row of podcasts whose
+// iTunes ID was already saved the same way UpsertPodcasts does
+func mergeStagedPodcasts() string {
+	columns := strings.Join(podcastCopyColumns, ", ")
+	updates := make([]string, len(podcastUpsertColumns))
+	for i, column := range podcastUpsertColumns {
+		updates[i] = fmt.Sprintf("%s = EXCLUDED.%s", column, column)
+	}
+
+	return fmt.Sprintf(
+		"INSERT INTO podcasts (%s) SELECT %s FROM %s ON CONFLICT (itunes_id) DO UPDATE SET %s",
+		columns,
+		columns,
+		stagingPodcastsTable,
+		strings.Join(updates, ", "),
+	)
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"gorm.io/gorm"
+)
+
+func CreateRun(db *gorm.DB, run *models.CrawlRun) error {
+	return db.Create(run).Error
+}
+
+// Writes the status, counters and finish time of run
+func UpdateRun(db *gorm.DB, run *models.CrawlRun) error {
+	return db.Model(run).
+		Select("status", "saved", "failed", "requeued", "finished_at", "updated_at").
+		Updates(run).Error
+}
+
+// Returns the limit most recently started runs, newest first
+func RecentRuns(db *gorm.DB, limit int) ([]models.CrawlRun, error) {
+	var runs []models.CrawlRun
+	err := db.Order("started_at DESC").Limit(limit).Find(&runs).Error
+
+	return runs, err
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Records failures in the dead letter table and marks their IDs as failed in
+// the crawl queue. Attempt counts are copied from the crawl queue. IDs that
+// were already dead lettered keep their first failure time
+func RecordFailures(db *gorm.DB, failures []models.DeadLetter) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, chunk := range utils.Chunk(failures, queueChunkSize) {
+			ids := make([]uint64, len(chunk))
+			for i := range chunk {
+				ids[i] = chunk[i].ItunesID
+			}
+
+			var queued []models.CrawlQueueItem
+			err := tx.Select("itunes_id", "attempts").Where("itunes_id IN ?", ids).Find(&queued).Error
+			if err != nil {
+				return err
+			}
+			attempts := make(map[uint64]uint32, len(queued))
+			for _, item := range queued {
+				attempts[item.ItunesID] = item.Attempts
+			}
+
+			now := time.Now()
+			rows := make([]models.DeadLetter, len(chunk))
+			for i, failure := range chunk {
+				failure.Attempts = attempts[failure.ItunesID]
+				failure.FirstFailedAt = now
+				failure.LastFailedAt = now
+				rows[i] = failure
+			}
+
+			err = tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "itunes_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"category", "http_status", "attempts", "last_failed_at", "crawl_run_id"}),
+			}).Create(&rows).Error
+			if err != nil {
+				return err
+			}
+
+			if err := MarkFailed(tx, ids); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Lists dead lettered IDs, most recent failures first. An empty category
+// matches every category and a limit of 0 or less returns all entries
+func ListDeadLetters(db *gorm.DB, category models.FailureCategory, limit int) ([]models.DeadLetter, error) {
+	query := db.Order("last_failed_at DESC, itunes_id")
+	if category != "" {
+		query = query.Where("category = ?", category)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var deadLetters []models.DeadLetter
+	err := query.Find(&deadLetters).Error
+
+	return deadLetters, err
+}
+
+// Moves dead lettered IDs back into the crawl queue as pending with a fresh
+// attempt count. When ids is empty, every entry matching category is requeued.
+// Returns the number of requeued IDs
+func RequeueDeadLetters(db *gorm.DB, ids []uint64, category models.FailureCategory) (int64, error) {
+	var requeued int64
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.DeadLetter{})
+		if len(ids) > 0 {
+			query = query.Where("itunes_id IN ?", ids)
+		}
+		if category != "" {
+			query = query.Where("category = ?", category)
+		}
+
+		var deadIds []uint64
+		if err := query.Pluck("itunes_id", &deadIds).Error; err != nil {
+			return err
+		}
+
+		if err := Requeue(tx, deadIds); err != nil {
+			return err
+		}
+
+		for _, chunk := range utils.Chunk(deadIds, queueChunkSize) {
+			err := tx.Where("itunes_id IN ?", chunk).Delete(&models.DeadLetter{}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		requeued = int64(len(deadIds))
+		return nil
+	})
+
+	return requeued, err
+}
+
+// Counts dead lettered IDs by failure category
+func DeadLetterCounts(db *gorm.DB) (map[models.FailureCategory]int64, error) {
+	var rows []struct {
+		Category models.FailureCategory
+		Count    int64
+	}
+	err := db.Model(&models.DeadLetter{}).
+		Select("category, COUNT(*) AS count").
+		Group("category").
+		Scan(&rows).Error
+
+	counts := make(map[models.FailureCategory]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Category] = row.Count
+	}
+
+	return counts, err
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Genre IDs by name, loaded from the genres table on first use and shared by
+// every save of a run. Genres created in a transaction are only cached once
+// the caller has committed it and passed them to Remember, so a rollback
+// never leaves unknown IDs behind
+type GenreCache struct {
+	mutex  sync.RWMutex
+	loaded bool
+	ids    map[string]string
+}
+
+func NewGenreCache() *GenreCache {
+	return &GenreCache{ids: make(map[string]string)}
+}
+
+// Returns the IDs of genre names, creating genres that don't exist yet in a
+// single statement per chunk
+func (c *GenreCache) Resolve(db *gorm.DB, names []string) (map[string]string, error) {
+	if err := c.load(db); err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string]string, len(names))
+	missing := make([]string, 0)
+	c.mutex.RLock()
+	for _, name := range names {
+		if id, ok := c.ids[name]; ok {
+			resolved[name] = id
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	c.mutex.RUnlock()
+
+	for _, chunk := range utils.Chunk(missing, queueChunkSize) {
+		genres := make([]models.Genre, len(chunk))
+		for i := range chunk {
+			genres[i] = models.Genre{Name: &chunk[i]}
+		}
+
+		// Genres created by a concurrent save are picked up by the select
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoNothing: true,
+		}).Create(&genres).Error
+		if err != nil {
+			return nil, err
+		}
+
+		var found []models.Genre
+		if err := db.Unscoped().Where("name IN ?", chunk).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, genre := range found {
+			resolved[*genre.Name] = genre.ID
+		}
+	}
+
+	return resolved, nil
+}
+
+// Caches genre IDs returned by Resolve once they are committed
+func (c *GenreCache) Remember(ids map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for name, id := range ids {
+		c.ids[name] = id
+	}
+}
+
+func (c *GenreCache) load(db *gorm.DB) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.loaded {
+		return nil
+	}
+
+	var genres []models.Genre
+	if err := db.Unscoped().Find(&genres).Error; err != nil {
+		return err
+	}
+	for _, genre := range genres {
+		c.ids[*genre.Name] = genre.ID
+	}
+	c.loaded = true
+
+	return nil
+}
+
+// Returns the distinct genre names of podcasts
+func GenreNames(podcasts []models.Podcast) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, p := range podcasts {
+		for _, name := range p.GenreNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// Links podcasts to the genres named in their GenreNames, using IDs from
+// Resolve. The primary genre is only set when it's one of the podcast's genres
+func SetGenres(podcasts []models.Podcast, ids map[string]string) {
+	for i := range podcasts {
+		p := &podcasts[i]
+		p.PodcastGenres = make([]models.PodcastGenre, 0, len(p.GenreNames))
+		p.PrimaryGenreID = nil
+
+		for _, name := range p.GenreNames {
+			id, ok := ids[name]
+			if !ok {
+				continue
+			}
+
+			p.PodcastGenres = append(p.PodcastGenres, models.PodcastGenre{GenreID: id})
+			if p.PrimaryGenreName != nil && *p.PrimaryGenreName == name {
+				p.PrimaryGenreID = &id
+			}
+		}
+	}
+}
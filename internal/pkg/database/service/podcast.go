@@ -2,13 +2,47 @@ package service
 
 import (
 	"strings"
+	"time"
 
-	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func PodcastFromItunesResult(result podcast.ItunesResult) (*models.Podcast, error) {
+// Keeps the number of bound parameters per statement well under Postgres'
+// limit, since podcasts have about 25 columns
+const podcastChunkSize = 1000
+
+// Podcast columns compared by PodcastChanges
+var podcastTrackedColumns = []string{
+	"title",
+	"censored_title",
+	"feed_url",
+	"artist_name",
+	"release_date",
+	"description",
+	"country",
+	"episode_count",
+	"content_advisory_rating",
+	"itunes_view_url",
+	"itunes_artwork_url30",
+	"itunes_artwork_url60",
+	"itunes_artwork_url100",
+	"itunes_artwork_url600",
+	"itunes_artist_id",
+	"itunes_artist_view_url",
+	"primary_genre_id",
+}
+
+// Columns overwritten when an upserted podcast was already saved. The row ID
+// and creation time are kept
+var podcastUpsertColumns = append([]string{"updated_at", "crawl_run_id", "raw"}, podcastTrackedColumns...)
+
+// Builds the model of a lookup result. Genres are only named here and are
+// linked with SetGenres when the podcast is saved
+func PodcastFromItunesResult(result podcast.ItunesResult) models.Podcast {
 	p := models.Podcast{
 		Title:         *result.CollectionName,
 		CensoredTitle: *result.CollectionCensoredName,
@@ -29,100 +63,204 @@ func PodcastFromItunesResult(result podcast.ItunesResult) (*models.Podcast, erro
 
 		ItunesArtistId:      result.ArtistId,
 		ItunesArtistViewUrl: result.ArtistViewUrl,
-	}
 
-	db, _ := database.GetInstance()
+		Raw: models.JSON(result.Raw),
+	}
 
 	// Cleanup genres
-	resultGenres := make([]string, 0, len(result.Genres))
+	p.GenreNames = make([]string, 0, len(result.Genres))
 	for _, genre := range result.Genres {
 		g := strings.TrimSpace(genre)
 		if len(g) > 0 {
-			resultGenres = append(resultGenres, g)
+			p.GenreNames = append(p.GenreNames, g)
 		}
 	}
+	if result.PrimaryGenreName != nil && len(*result.PrimaryGenreName) > 0 {
+		p.PrimaryGenreName = result.PrimaryGenreName
+	}
+
+	return p
+}
+
+// Selects saved podcasts to look up again or export. Zero values match every
+// podcast
+type StaleFilter struct {
+	OlderThan time.Duration // Last updated longer ago than this
+	Country   string
+	Genre     string // Genre name
+	Limit     int
+}
+
+// Returns iTunes IDs of saved podcasts matching filter, least recently
+// updated first
+func StalePodcastIDs(db *gorm.DB, filter StaleFilter) ([]uint64, error) {
+	query := filterPodcasts(db, filter).
+		Where("itunes_id IS NOT NULL").
+		Order("COALESCE(updated_at, created_at), itunes_id")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var ids []uint64
+	err := query.Pluck("itunes_id", &ids).Error
+	return ids, err
+}
 
-	// // Cleanup primary genre
-	// var primaryGenre models.Genre
-	// if result.PrimaryGenreName != nil {
-	// 	g := strings.TrimSpace(*result.PrimaryGenreName)
-	// 	if len(g) > 0 {
-	// 		db.Where("name = ?", result.PrimaryGenreName).FirstOrCreate(&primaryGenre)
-	// 		if db.Error != nil {
-	// 			// db.Rollback()
-	// 			return nil, db.Error
-	// 		}
-	// 		p.PrimaryGenreID = &primaryGenre.ID
-	// 	}
-	// }
-	hasPrimaryGenre := result.PrimaryGenreName != nil && len(*result.PrimaryGenreName) > 0
-
-	// Use genres already in db, create the ones that aren't
-	if len(resultGenres) > 0 {
-		var genres []models.Genre
-		db.Where("name IN ?", resultGenres).Find(&genres)
-		if db.Error != nil {
-			return nil, db.Error
+// Calls fn with saved podcasts matching filter, batchSize at a time, with
+// their genres loaded. Stops at the first error fn returns
+func EachPodcast(db *gorm.DB, filter StaleFilter, batchSize int, fn func([]models.Podcast) error) error {
+	query := filterPodcasts(db, filter).
+		Preload("PrimaryGenre").
+		Preload("PodcastGenres.Genre")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var batch []models.Podcast
+	return query.FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
+
+// Scopes a podcast query to filter, leaving order and limit to the caller
+func filterPodcasts(db *gorm.DB, filter StaleFilter) *gorm.DB {
+	query := db.Model(&models.Podcast{})
+	if filter.OlderThan > 0 {
+		query = query.Where("COALESCE(updated_at, created_at) < ?", time.Now().Add(-filter.OlderThan))
+	}
+	if filter.Country != "" {
+		query = query.Where("country = ?", filter.Country)
+	}
+	if filter.Genre != "" {
+		tagged := db.Model(&models.PodcastGenre{}).
+			Select("podcast_genres.podcast_id").
+			Joins("JOIN genres ON genres.id = podcast_genres.genre_id").
+			Where("genres.name = ?", filter.Genre)
+		query = query.Where("id IN (?)", tagged)
+	}
+
+	return query
+}
+
+// Saves podcasts, updating the existing row of any podcast whose iTunes ID was
+// already saved instead of adding a duplicate. Genres of every saved podcast
+// are replaced with the ones given. Returns the number of podcasts written
+func UpsertPodcasts(db *gorm.DB, podcasts []models.Podcast) (int64, error) {
+	var written int64
+
+	for _, chunk := range utils.Chunk(uniquePodcasts(podcasts), podcastChunkSize) {
+		result := db.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "itunes_id"}},
+			DoUpdates: clause.AssignmentColumns(podcastUpsertColumns),
+		}).Create(&chunk)
+		if result.Error != nil {
+			return written, result.Error
 		}
+		written += result.RowsAffected
 
-		unsavedGenres := make([]models.Genre, 0)
-		for _, genreName := range resultGenres {
-			foundInDb := false
-			for _, genre := range genres {
-				if *genre.Name == genreName {
-					p.PodcastGenres = append(
-						p.PodcastGenres,
-						models.PodcastGenre{
-							GenreID: genre.ID,
-						},
-					)
-					foundInDb = true
-
-					if hasPrimaryGenre {
-						isPrimaryGenre := *genre.Name == *result.PrimaryGenreName
-						if isPrimaryGenre {
-
-							p.PrimaryGenreID = &genre.ID
-						}
-					}
-
-					break
-				}
-			}
-
-			if foundInDb {
-				continue
-			}
-
-			g := genreName
-			unsavedGenres = append(unsavedGenres, models.Genre{
-				Name: &g,
-			})
+		if err := loadPodcastIDs(db, chunk); err != nil {
+			return written, err
+		}
+		if err := replacePodcastGenres(db, chunk); err != nil {
+			return written, err
 		}
+	}
 
-		if len(unsavedGenres) > 0 {
-			db.Create(unsavedGenres)
-			if db.Error != nil {
-				return nil, db.Error
-			}
-
-			for _, createdGenre := range unsavedGenres {
-				p.PodcastGenres = append(
-					p.PodcastGenres,
-					models.PodcastGenre{
-						GenreID: createdGenre.ID,
-					},
-				)
-
-				if hasPrimaryGenre {
-					isPrimaryGenre := *createdGenre.Name == *result.PrimaryGenreName
-					if isPrimaryGenre {
-						p.PrimaryGenreID = &createdGenre.ID
-					}
-				}
-			}
+	return written, nil
+}
+
+// Sets the IDs of podcasts to those of their rows. Podcasts that updated an
+// existing row still hold the ID generated for them before the upsert, and
+// not every backend returns the IDs of updated rows
+func loadPodcastIDs(db *gorm.DB, podcasts []models.Podcast) error {
+	itunesIds := make([]uint32, 0, len(podcasts))
+	for _, p := range podcasts {
+		if p.ItunesID != nil {
+			itunesIds = append(itunesIds, *p.ItunesID)
+		}
+	}
+	if len(itunesIds) == 0 {
+		return nil
+	}
+
+	var rows []models.Podcast
+	err := db.Unscoped().
+		Select("id", "itunes_id").
+		Where("itunes_id IN ?", itunesIds).
+		Find(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[uint32]string, len(rows))
+	for _, row := range rows {
+		ids[*row.ItunesID] = row.ID
+	}
+	for i, p := range podcasts {
+		if p.ItunesID == nil {
+			continue
+		}
+		if id, ok := ids[*p.ItunesID]; ok {
+			podcasts[i].ID = id
+		}
+	}
+
+	return nil
+}
+
+// Keeps the last of podcasts sharing an iTunes ID, since a single upsert
+// can't update the same row twice
+func uniquePodcasts(podcasts []models.Podcast) []models.Podcast {
+	positions := make(map[uint32]int, len(podcasts))
+	unique := make([]models.Podcast, 0, len(podcasts))
+	for _, p := range podcasts {
+		if p.ItunesID == nil {
+			unique = append(unique, p)
+			continue
+		}
+		if i, ok := positions[*p.ItunesID]; ok {
+			unique[i] = p
+			continue
+		}
+		positions[*p.ItunesID] = len(unique)
+		unique = append(unique, p)
+	}
+
+	return unique
+}
+
+// Replaces the genre links of saved podcasts with their PodcastGenres
+func replacePodcastGenres(db *gorm.DB, podcasts []models.Podcast) error {
+	ids := make([]string, len(podcasts))
+	genres := make([]models.PodcastGenre, 0, len(podcasts))
+	for i, p := range podcasts {
+		ids[i] = p.ID
+		for _, genre := range p.PodcastGenres {
+			genres = append(genres, models.PodcastGenre{
+				PodcastID: p.ID,
+				GenreID:   genre.GenreID,
+			})
 		}
 	}
 
-	return &p, nil
+	err := db.Where("podcast_id IN ?", ids).Delete(&models.PodcastGenre{}).Error
+	if err != nil {
+		return err
+	}
+	if len(genres) == 0 {
+		return nil
+	}
+
+	return db.Omit(clause.Associations).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(genres, podcastChunkSize).
+		Error
+}
+
+// Counts saved podcasts
+func CountPodcasts(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&models.Podcast{}).Count(&count).Error
+
+	return count, err
 }
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// A podcast column whose value changed between crawls
+type FieldChange struct {
+	Column string
+	Old    interface{}
+	New    interface{}
+}
+
+func (c FieldChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Column, c.Old, c.New)
+}
+
+// Changes found between a saved podcast and a fresh lookup of it
+type PodcastChange struct {
+	ItunesID uint32
+	Fields   []FieldChange
+}
+
+func (c PodcastChange) String() string {
+	fields := make([]string, len(c.Fields))
+	for i, field := range c.Fields {
+		fields[i] = field.String()
+	}
+
+	return strings.Join(fields, ", ")
+}
+
+// Compares podcasts with the saved podcasts sharing their iTunes IDs. Only
+// metadata columns are compared. Podcasts that weren't saved before and
+// podcasts that didn't change are left out
+func PodcastChanges(db *gorm.DB, podcasts []models.Podcast) ([]PodcastChange, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&models.Podcast{}); err != nil {
+		return nil, err
+	}
+
+	podcasts = uniquePodcasts(podcasts)
+	ids := make([]uint32, 0, len(podcasts))
+	for _, p := range podcasts {
+		if p.ItunesID != nil {
+			ids = append(ids, *p.ItunesID)
+		}
+	}
+
+	saved := make(map[uint32]models.Podcast, len(ids))
+	for _, chunk := range utils.Chunk(ids, podcastChunkSize) {
+		var rows []models.Podcast
+		if err := db.Where("itunes_id IN ?", chunk).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			saved[*row.ItunesID] = row
+		}
+	}
+
+	changes := make([]PodcastChange, 0)
+	for _, p := range podcasts {
+		if p.ItunesID == nil {
+			continue
+		}
+		old, ok := saved[*p.ItunesID]
+		if !ok {
+			continue
+		}
+
+		fields := diffPodcast(stmt.Schema, old, p)
+		if len(fields) > 0 {
+			changes = append(changes, PodcastChange{
+				ItunesID: *p.ItunesID,
+				Fields:   fields,
+			})
+		}
+	}
+
+	return changes, nil
+}
+
+func diffPodcast(s *schema.Schema, old models.Podcast, new models.Podcast) []FieldChange {
+	ctx := context.Background()
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+
+	changes := make([]FieldChange, 0)
+	for _, column := range podcastTrackedColumns {
+		field := s.LookUpField(column)
+		before, _ := field.ValueOf(ctx, oldValue)
+		after, _ := field.ValueOf(ctx, newValue)
+		before, after = indirect(before), indirect(after)
+		if !reflect.DeepEqual(before, after) {
+			changes = append(changes, FieldChange{
+				Column: column,
+				Old:    before,
+				New:    after,
+			})
+		}
+	}
+
+	return changes
+}
+
+// Dereferences pointer values so nullable columns compare by value
+func indirect(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Pointer {
+		return value
+	}
+	if v.IsNil() {
+		return nil
+	}
+
+	return v.Elem().Interface()
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"gorm.io/gorm"
+)
+
+// Persistence backends selectable with the persistenceBackend setting
+const (
+	BackendGorm = "gorm" // Upserts through GORM
+	BackendCopy = "copy" // COPY into staging tables followed by a set-based merge
+)
+
+// Writes podcasts and replaces their genre links as part of an open
+// transaction. conn is the single-connection session tx was begun on, which
+// backends that talk to the driver directly need to join the transaction
+type PodcastWriter interface {
+	Write(conn *gorm.DB, tx *gorm.DB, podcasts []models.Podcast) (int64, error)
+}
+
+func NewPodcastWriter(backend string) (PodcastWriter, error) {
+	switch backend {
+	case BackendGorm:
+		return upsertWriter{}, nil
+	case BackendCopy:
+		return copyWriter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown persistence backend `%s`", backend)
+	}
+}
+
+type upsertWriter struct{}
+
+func (upsertWriter) Write(conn *gorm.DB, tx *gorm.DB, podcasts []models.Podcast) (int64, error) {
+	return UpsertPodcasts(tx, podcasts)
+}
+
+type copyWriter struct{}
+
+func (copyWriter) Write(conn *gorm.DB, tx *gorm.DB, podcasts []models.Podcast) (int64, error) {
+	return CopyPodcasts(conn, tx, podcasts)
+}
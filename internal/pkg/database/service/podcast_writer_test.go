@@ -0,0 +1,106 @@
+package service_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/migrations"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// Postgres DSN of a scratch database the writer benchmarks may migrate.
+// Benchmarks are skipped when it isn't set
+const benchmarkDSNVariable = "PODCRAWLER_BENCH_DSN"
+
+const benchmarkBatchSize = 1000
+
+func BenchmarkUpsertWriter(b *testing.B) {
+	benchmarkWriter(b, service.BackendGorm)
+}
+
+func BenchmarkCopyWriter(b *testing.B) {
+	benchmarkWriter(b, service.BackendCopy)
+}
+
+// Writes the same batch with backend in a transaction that is rolled back,
+// so every iteration inserts the whole batch
+func benchmarkWriter(b *testing.B, backend string) {
+	dsn := os.Getenv(benchmarkDSNVariable)
+	if dsn == "" {
+		b.Skipf("%s is not set", benchmarkDSNVariable)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	list, err := migrations.ForBackend("postgres")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := migrations.New(db, list).Up(); err != nil {
+		b.Fatal(err)
+	}
+
+	writer, err := service.NewPodcastWriter(backend)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	podcasts := benchmarkPodcasts(benchmarkBatchSize)
+	genreIds, err := service.NewGenreCache().Resolve(db, service.GenreNames(podcasts))
+	if err != nil {
+		b.Fatal(err)
+	}
+	service.SetGenres(podcasts, genreIds)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batch := append([]models.Podcast(nil), podcasts...)
+		err := db.Connection(func(conn *gorm.DB) error {
+			tx := conn.Begin()
+			defer tx.Rollback()
+
+			_, err := writer.Write(conn, tx, batch)
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(benchmarkBatchSize*b.N)/b.Elapsed().Seconds(), "podcasts/s")
+}
+
+func benchmarkPodcasts(count int) []models.Podcast {
+	genres := []string{"Podcasts", "Comedy", "News", "Technology", "Education", "Music"}
+
+	podcasts := make([]models.Podcast, count)
+	for i := range podcasts {
+		name := fmt.Sprintf("Benchmark podcast %d", i)
+		feedUrl := fmt.Sprintf("https://example.com/feeds/%d.xml", i)
+		primaryGenre := genres[i%len(genres)]
+		result := podcast.ItunesResult{
+			CollectionId:           uint32(4_000_000_000 + i),
+			CollectionName:         &name,
+			CollectionCensoredName: &name,
+			ArtistName:             "Benchmark artist",
+			FeedUrl:                &feedUrl,
+			TrackCount:             uint32(i % 500),
+			Country:                "USA",
+			PrimaryGenreName:       &primaryGenre,
+			Genres:                 []string{primaryGenre, genres[0]},
+			Raw:                    []byte(fmt.Sprintf(`{"collectionId":%d,"kind":"podcast"}`, 4_000_000_000+i)),
+		}
+		podcasts[i] = service.PodcastFromItunesResult(result)
+	}
+
+	return podcasts
+}
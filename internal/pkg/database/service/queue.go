@@ -0,0 +1,159 @@
+package service
+
+import (
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Keeps the number of bound parameters per statement well under Postgres' limit
+const queueChunkSize = 1000
+
+// Adds ids to the crawl queue as pending. IDs already in the queue keep their
+// current state. Returns the number of newly queued IDs
+func EnqueueIDs(db *gorm.DB, ids []uint64) (int64, error) {
+	var enqueued int64
+
+	for _, chunk := range utils.Chunk(ids, queueChunkSize) {
+		items := make([]models.CrawlQueueItem, len(chunk))
+		for i, id := range chunk {
+			items[i] = models.CrawlQueueItem{
+				ItunesID: id,
+				State:    models.QueuePending,
+			}
+		}
+
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&items)
+		if result.Error != nil {
+			return enqueued, result.Error
+		}
+		enqueued += result.RowsAffected
+	}
+
+	return enqueued, nil
+}
+
+// Returns IDs left in flight by an interrupted run to the pending state
+func ResetInFlight(db *gorm.DB) (int64, error) {
+	result := db.Model(&models.CrawlQueueItem{}).
+		Where("state = ?", models.QueueInFlight).
+		Update("state", models.QueuePending)
+
+	return result.RowsAffected, result.Error
+}
+
+// Marks queued IDs that already have a saved podcast as done
+func MarkCrawled(db *gorm.DB) (int64, error) {
+	crawled := db.Model(&models.Podcast{}).Select("itunes_id")
+	result := db.Model(&models.CrawlQueueItem{}).
+		Where("state <> ?", models.QueueDone).
+		Where("itunes_id IN (?)", crawled).
+		Update("state", models.QueueDone)
+
+	return result.RowsAffected, result.Error
+}
+
+// Counts the items waiting to be looked up
+func CountPending(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&models.CrawlQueueItem{}).
+		Where("state = ?", models.QueuePending).
+		Count(&count).Error
+
+	return count, err
+}
+
+// Returns up to limit pending items with IDs above after, in ID order. Only
+// the columns needed to schedule their lookups are read
+func PendingPage(db *gorm.DB, after uint64, limit int) ([]models.CrawlQueueItem, error) {
+	var items []models.CrawlQueueItem
+	err := db.Select("itunes_id", "attempts", "next_attempt_at").
+		Where("state = ? AND itunes_id > ?", models.QueuePending, after).
+		Order("itunes_id").
+		Limit(limit).
+		Find(&items).Error
+
+	return items, err
+}
+
+// Marks ids as in flight and counts the lookup attempt
+func MarkInFlight(db *gorm.DB, ids []uint64) error {
+	now := time.Now()
+	return updateQueue(db, ids, map[string]interface{}{
+		"state":           models.QueueInFlight,
+		"attempts":        gorm.Expr("attempts + 1"),
+		"last_attempt_at": &now,
+		"next_attempt_at": nil,
+	})
+}
+
+// Returns ids to pending, to be looked up again no earlier than nextAttemptAt
+func ScheduleRetry(db *gorm.DB, ids []uint64, nextAttemptAt time.Time) error {
+	return updateQueue(db, ids, map[string]interface{}{
+		"state":           models.QueuePending,
+		"next_attempt_at": &nextAttemptAt,
+	})
+}
+
+// Queues ids as pending with a fresh retry budget, whatever state they were
+// in before
+func Requeue(db *gorm.DB, ids []uint64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := EnqueueIDs(tx, ids); err != nil {
+			return err
+		}
+
+		return updateQueue(tx, ids, map[string]interface{}{
+			"state":           models.QueuePending,
+			"attempts":        0,
+			"next_attempt_at": nil,
+		})
+	})
+}
+
+func MarkDone(db *gorm.DB, ids []uint64) error {
+	return setQueueState(db, ids, models.QueueDone)
+}
+
+func MarkFailed(db *gorm.DB, ids []uint64) error {
+	return setQueueState(db, ids, models.QueueFailed)
+}
+
+func setQueueState(db *gorm.DB, ids []uint64, state models.QueueState) error {
+	return updateQueue(db, ids, map[string]interface{}{"state": state})
+}
+
+func updateQueue(db *gorm.DB, ids []uint64, values map[string]interface{}) error {
+	for _, chunk := range utils.Chunk(ids, queueChunkSize) {
+		err := db.Model(&models.CrawlQueueItem{}).
+			Where("itunes_id IN ?", chunk).
+			Updates(values).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Counts crawl queue items by state
+func QueueCounts(db *gorm.DB) (map[models.QueueState]int64, error) {
+	var rows []struct {
+		State models.QueueState
+		Count int64
+	}
+	err := db.Model(&models.CrawlQueueItem{}).
+		Select("state, COUNT(*) AS count").
+		Group("state").
+		Scan(&rows).Error
+
+	counts := make(map[models.QueueState]int64, len(rows))
+	for _, row := range rows {
+		counts[row.State] = row.Count
+	}
+
+	return counts, err
+}
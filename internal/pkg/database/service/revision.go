@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"gorm.io/gorm"
+)
+
+// Podcast columns whose changes are recorded as revisions
+var revisionColumns = map[string]bool{
+	"feed_url":      true,
+	"title":         true,
+	"artist_name":   true,
+	"episode_count": true,
+}
+
+// Records the changes to revision columns in changes as revisions of crawl
+// run runID. Changes to other columns are left out
+func RecordRevisions(db *gorm.DB, runID string, changes []PodcastChange) error {
+	now := time.Now()
+	revisions := make([]models.PodcastRevision, 0, len(changes))
+	for _, change := range changes {
+		for _, field := range change.Fields {
+			if !revisionColumns[field.Column] {
+				continue
+			}
+			revisions = append(revisions, models.PodcastRevision{
+				ItunesID:  uint64(change.ItunesID),
+				RunID:     runID,
+				Field:     field.Column,
+				OldValue:  revisionValue(field.Old),
+				NewValue:  revisionValue(field.New),
+				ChangedAt: now,
+			})
+		}
+	}
+	if len(revisions) == 0 {
+		return nil
+	}
+
+	return db.CreateInBatches(revisions, queueChunkSize).Error
+}
+
+// Returns the recorded changes of a podcast, oldest first
+func PodcastHistory(db *gorm.DB, itunesID uint64) ([]models.PodcastRevision, error) {
+	var revisions []models.PodcastRevision
+	err := db.Where("itunes_id = ?", itunesID).
+		Order("changed_at, id").
+		Find(&revisions).Error
+
+	return revisions, err
+}
+
+func revisionValue(value interface{}) *string {
+	if value == nil {
+		return nil
+	}
+
+	s := fmt.Sprint(value)
+	return &s
+}
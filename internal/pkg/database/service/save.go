@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// How a failed save transaction should be handled
+type SaveErrorKind int
+
+const (
+	SaveErrorFatal     SaveErrorKind = iota // Nothing in the batch can be saved right now
+	SaveErrorRetryable                      // The transaction may succeed if run again
+	SaveErrorRow                            // A row of the batch was refused and will be refused again
+)
+
+func (k SaveErrorKind) String() string {
+	switch k {
+	case SaveErrorRetryable:
+		return "retryable"
+	case SaveErrorRow:
+		return "row"
+	default:
+		return "fatal"
+	}
+}
+
+// SQLSTATE codes of transient errors worth running the transaction again for
+var retryableCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"57014": true, // query_canceled, raised by statement timeouts
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// SQLSTATE classes, the first two characters of a code, by how their errors
+// are handled
+var errorClasses = map[string]SaveErrorKind{
+	"08": SaveErrorRetryable, // connection_exception
+	"53": SaveErrorRetryable, // insufficient_resources
+	"22": SaveErrorRow,       // data_exception
+	"23": SaveErrorRow,       // integrity_constraint_violation
+}
+
+// Primary SQLite result codes, the low byte of extended codes, by how their
+// errors are handled
+var sqliteResultCodes = map[int]SaveErrorKind{
+	5:  SaveErrorRetryable, // SQLITE_BUSY
+	6:  SaveErrorRetryable, // SQLITE_LOCKED
+	18: SaveErrorRow,       // SQLITE_TOOBIG
+	19: SaveErrorRow,       // SQLITE_CONSTRAINT
+	20: SaveErrorRow,       // SQLITE_MISMATCH
+}
+
+// Classifies err by its Postgres error code or SQLite result code. Lost
+// connections, serialization failures, deadlocks and locked databases are
+// retryable, constraint violations and bad data are blamed on a row.
+// Anything else, like a missing table, is fatal
+func ClassifySaveError(err error) SaveErrorKind {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if retryableCodes[pgErr.Code] {
+			return SaveErrorRetryable
+		}
+		if len(pgErr.Code) >= 2 {
+			if kind, ok := errorClasses[pgErr.Code[:2]]; ok {
+				return kind
+			}
+		}
+		return SaveErrorFatal
+	}
+
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		if kind, ok := sqliteResultCodes[sqliteErr.Code()&0xff]; ok {
+			return kind
+		}
+		return SaveErrorFatal
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr),
+		pgconn.SafeToRetry(err),
+		pgconn.Timeout(err):
+		return SaveErrorRetryable
+	}
+
+	return SaveErrorFatal
+}
+
+// A podcast the database refused to save
+type Rejection struct {
+	Podcast models.Podcast
+	Err     error
+}
+
+// Outcome of PodcastSaver.Save. Every podcast passed to Save ends up in
+// exactly one of Saved, Rejected and Unsaved
+type SaveResult struct {
+	Written  int64 // Rows written, as reported by the writer
+	Saved    []models.Podcast
+	Changes  []PodcastChange // Changes of saved podcasts since their last crawl
+	Rejected []Rejection     // Podcasts isolated as the cause of row errors
+	Unsaved  []models.Podcast
+}
+
+// Saves batches of podcasts, each attempt in a fresh transaction. Retryable
+// errors are retried with backoff, and batches failing with a row error are
+// split in halves until the offending podcasts are isolated
+type PodcastSaver struct {
+	Writer      PodcastWriter
+	Genres      *GenreCache
+	Attempts    int // Attempts per transaction before a retryable error is given up on
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	// Called before waiting to retry a failed attempt. Optional
+	OnRetry func(err error, attempt int, wait time.Duration)
+}
+
+// Saves podcasts as part of crawl run runID, along with their genres and the
+// revisions of changed fields. The error is the one that left podcasts in
+// SaveResult.Unsaved; rejected podcasts alone don't cause one
+func (s *PodcastSaver) Save(db *gorm.DB, runID string, podcasts []models.Podcast) (SaveResult, error) {
+	var result SaveResult
+	err := s.save(db, runID, uniquePodcasts(podcasts), &result)
+	return result, err
+}
+
+func (s *PodcastSaver) save(db *gorm.DB, runID string, batch []models.Podcast, result *SaveResult) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	written, changes, err := s.retry(db, runID, batch)
+	if err == nil {
+		result.Written += written
+		result.Saved = append(result.Saved, batch...)
+		result.Changes = append(result.Changes, changes...)
+		return nil
+	}
+
+	if ClassifySaveError(err) != SaveErrorRow {
+		result.Unsaved = append(result.Unsaved, batch...)
+		return err
+	}
+	if len(batch) == 1 {
+		result.Rejected = append(result.Rejected, Rejection{Podcast: batch[0], Err: err})
+		return nil
+	}
+
+	half := len(batch) / 2
+	if err := s.save(db, runID, batch[:half], result); err != nil {
+		result.Unsaved = append(result.Unsaved, batch[half:]...)
+		return err
+	}
+	return s.save(db, runID, batch[half:], result)
+}
+
+// Runs the batch transaction until it succeeds, fails with an error that
+// isn't retryable or runs out of attempts
+func (s *PodcastSaver) retry(db *gorm.DB, runID string, batch []models.Podcast) (int64, []PodcastChange, error) {
+	for attempt := 1; ; attempt++ {
+		written, changes, err := s.transaction(db, runID, batch)
+		if err == nil {
+			return written, changes, nil
+		}
+		if attempt >= s.Attempts || ClassifySaveError(err) != SaveErrorRetryable {
+			return 0, nil, err
+		}
+
+		wait := utils.ExponentialBackoff(attempt, s.BackoffBase, s.BackoffMax)
+		if s.OnRetry != nil {
+			s.OnRetry(err, attempt, wait)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Saves batch in a transaction of its own. The writer works on a copy so
+// IDs assigned by a rolled back attempt don't leak into the next one
+func (s *PodcastSaver) transaction(db *gorm.DB, runID string, batch []models.Podcast) (int64, []PodcastChange, error) {
+	podcasts := append([]models.Podcast(nil), batch...)
+
+	var written int64
+	var changes []PodcastChange
+	var genreIds map[string]string
+
+	// Pinned to a single connection so writers that use the driver directly
+	// can join the transaction
+	err := db.Connection(func(conn *gorm.DB) error {
+		return conn.Transaction(func(tx *gorm.DB) error {
+			var err error
+			genreIds, err = s.Genres.Resolve(tx, GenreNames(podcasts))
+			if err != nil {
+				return err
+			}
+			SetGenres(podcasts, genreIds)
+
+			changes, err = PodcastChanges(tx, podcasts)
+			if err != nil {
+				return err
+			}
+			if err := RecordRevisions(tx, runID, changes); err != nil {
+				return err
+			}
+
+			written, err = s.Writer.Write(conn, tx, podcasts)
+			return err
+		})
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	s.Genres.Remember(genreIds)
+	return written, changes, nil
+}
@@ -0,0 +1,368 @@
+package service_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+func TestClassifySaveError(t *testing.T) {
+	tests := []struct {
+		title string
+		err   error
+		want  service.SaveErrorKind
+	}{
+		{
+			title: "Serialization failures are retryable",
+			err:   &pgconn.PgError{Code: "40001"},
+			want:  service.SaveErrorRetryable,
+		},
+		{
+			title: "Deadlocks are retryable",
+			err:   &pgconn.PgError{Code: "40P01"},
+			want:  service.SaveErrorRetryable,
+		},
+		{
+			title: "Connection exceptions are retryable",
+			err:   &pgconn.PgError{Code: "08006"},
+			want:  service.SaveErrorRetryable,
+		},
+		{
+			title: "Server shutdowns are retryable",
+			err:   &pgconn.PgError{Code: "57P01"},
+			want:  service.SaveErrorRetryable,
+		},
+		{
+			title: "Unique violations are row errors",
+			err:   &pgconn.PgError{Code: "23505"},
+			want:  service.SaveErrorRow,
+		},
+		{
+			title: "Not null violations are row errors",
+			err:   &pgconn.PgError{Code: "23502"},
+			want:  service.SaveErrorRow,
+		},
+		{
+			title: "Invalid text encodings are row errors",
+			err:   &pgconn.PgError{Code: "22021"},
+			want:  service.SaveErrorRow,
+		},
+		{
+			title: "Missing tables are fatal",
+			err:   &pgconn.PgError{Code: "42P01"},
+			want:  service.SaveErrorFatal,
+		},
+		{
+			title: "Wrapped Postgres errors are unwrapped",
+			err:   fmt.Errorf("saving podcasts: %w", &pgconn.PgError{Code: "23505"}),
+			want:  service.SaveErrorRow,
+		},
+		{
+			title: "Bad connections are retryable",
+			err:   driver.ErrBadConn,
+			want:  service.SaveErrorRetryable,
+		},
+		{
+			title: "Unexpected EOFs are retryable",
+			err:   fmt.Errorf("reading response: %w", io.ErrUnexpectedEOF),
+			want:  service.SaveErrorRetryable,
+		},
+		{
+			title: "Timeouts are retryable",
+			err:   context.DeadlineExceeded,
+			want:  service.SaveErrorRetryable,
+		},
+		{
+			title: "Network errors are retryable",
+			err:   &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			want:  service.SaveErrorRetryable,
+		},
+		{
+			title: "Unknown errors are fatal",
+			err:   errors.New("unsupported data type"),
+			want:  service.SaveErrorFatal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if got := service.ClassifySaveError(test.err); got != test.want {
+				t.Errorf("ClassifySaveError(%v) = %s, want %s", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+const saveRunID = "6f1c2a3e-4b5d-4e6f-8a7b-9c0d1e2f3a4b"
+
+var (
+	errRow       = &pgconn.PgError{Code: "23505"}
+	errRetryable = &pgconn.PgError{Code: "40001"}
+	errFatal     = errors.New("relation does not exist")
+)
+
+func openSaveDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	store, err := database.Open(database.Options{
+		Backend: database.BackendSQLite,
+		Path:    filepath.Join(t.TempDir(), "podcasts.db"),
+		Writer:  service.BackendGorm,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if _, err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return store.DB()
+}
+
+func savePodcasts(ids ...uint32) []models.Podcast {
+	podcasts := make([]models.Podcast, len(ids))
+	for i, id := range ids {
+		name := fmt.Sprintf("Podcast %d", id)
+		genre := "Comedy"
+		podcasts[i] = service.PodcastFromItunesResult(podcast.ItunesResult{
+			CollectionId:           id,
+			CollectionName:         &name,
+			CollectionCensoredName: &name,
+			ArtistName:             "Test artist",
+			Country:                "USA",
+			PrimaryGenreName:       &genre,
+			Genres:                 []string{genre},
+			Raw:                    []byte(`{"kind":"podcast"}`),
+		})
+	}
+
+	return podcasts
+}
+
+// Writes through the GORM writer, then fails the attempt with the error fail
+// returns for it. Writes of failed attempts must be rolled back
+type failingWriter struct {
+	fail     func(attempt int, podcasts []models.Podcast) error
+	attempts int
+	txs      map[*gorm.DB]bool // Transactions attempts were made in
+}
+
+func (w *failingWriter) Write(conn *gorm.DB, tx *gorm.DB, podcasts []models.Podcast) (int64, error) {
+	w.attempts++
+	if w.txs == nil {
+		w.txs = make(map[*gorm.DB]bool)
+	}
+	w.txs[tx] = true
+
+	writer, err := service.NewPodcastWriter(service.BackendGorm)
+	if err != nil {
+		return 0, err
+	}
+	written, err := writer.Write(conn, tx, podcasts)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := w.fail(w.attempts, podcasts); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// Fails every attempt holding one of ids with a row error
+func rejectIDs(ids ...uint32) func(int, []models.Podcast) error {
+	return func(_ int, podcasts []models.Podcast) error {
+		for _, p := range podcasts {
+			for _, id := range ids {
+				if *p.ItunesID == id {
+					return errRow
+				}
+			}
+		}
+		return nil
+	}
+}
+
+func itunesIDs(podcasts []models.Podcast) []uint32 {
+	ids := make([]uint32, len(podcasts))
+	for i, p := range podcasts {
+		ids[i] = *p.ItunesID
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+func savedIDs(t *testing.T, db *gorm.DB) []uint32 {
+	t.Helper()
+
+	var saved []models.Podcast
+	if err := db.Find(&saved).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return itunesIDs(saved)
+}
+
+func TestPodcastSaver(t *testing.T) {
+	tests := []struct {
+		title        string
+		fail         func(attempt int, podcasts []models.Podcast) error
+		attempts     int
+		wantErr      error
+		wantSaved    []uint32
+		wantRejected []uint32
+		wantUnsaved  []uint32
+		wantAttempts int
+		wantRetries  int
+	}{
+		{
+			title:        "Saves a batch in one attempt",
+			fail:         func(int, []models.Podcast) error { return nil },
+			attempts:     3,
+			wantSaved:    []uint32{1, 2, 3, 4},
+			wantRejected: []uint32{},
+			wantUnsaved:  []uint32{},
+			wantAttempts: 1,
+		},
+		{
+			title: "Retries retryable errors in a fresh transaction",
+			fail: func(attempt int, _ []models.Podcast) error {
+				if attempt < 3 {
+					return errRetryable
+				}
+				return nil
+			},
+			attempts:     3,
+			wantSaved:    []uint32{1, 2, 3, 4},
+			wantRejected: []uint32{},
+			wantUnsaved:  []uint32{},
+			wantAttempts: 3,
+			wantRetries:  2,
+		},
+		{
+			title:        "Gives up on retryable errors after the last attempt",
+			fail:         func(int, []models.Podcast) error { return errRetryable },
+			attempts:     2,
+			wantErr:      errRetryable,
+			wantSaved:    []uint32{},
+			wantRejected: []uint32{},
+			wantUnsaved:  []uint32{1, 2, 3, 4},
+			wantAttempts: 2,
+			wantRetries:  1,
+		},
+		{
+			title:        "Halves a batch to isolate a rejected row",
+			fail:         rejectIDs(3),
+			attempts:     3,
+			wantSaved:    []uint32{1, 2, 4},
+			wantRejected: []uint32{3},
+			wantUnsaved:  []uint32{},
+			// 1-4, 1-2, 3-4, 3, 4
+			wantAttempts: 5,
+		},
+		{
+			title:        "Isolates every rejected row",
+			fail:         rejectIDs(1, 4),
+			attempts:     3,
+			wantSaved:    []uint32{2, 3},
+			wantRejected: []uint32{1, 4},
+			wantUnsaved:  []uint32{},
+			// 1-4, 1-2, 1, 2, 3-4, 3, 4
+			wantAttempts: 7,
+		},
+		{
+			title: "Leaves the rest of a halved batch unsaved on a fatal error",
+			fail: func(attempt int, podcasts []models.Podcast) error {
+				if attempt == 1 {
+					return errRow
+				}
+				if *podcasts[0].ItunesID == 3 {
+					return errFatal
+				}
+				return nil
+			},
+			attempts:     3,
+			wantErr:      errFatal,
+			wantSaved:    []uint32{1, 2},
+			wantRejected: []uint32{},
+			wantUnsaved:  []uint32{3, 4},
+			wantAttempts: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			db := openSaveDB(t)
+			writer := &failingWriter{fail: test.fail}
+
+			var retries int
+			saver := &service.PodcastSaver{
+				Writer:      writer,
+				Genres:      service.NewGenreCache(),
+				Attempts:    test.attempts,
+				BackoffBase: time.Millisecond,
+				BackoffMax:  time.Millisecond,
+				OnRetry: func(err error, attempt int, wait time.Duration) {
+					retries++
+				},
+			}
+
+			result, err := saver.Save(db, saveRunID, savePodcasts(1, 2, 3, 4))
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Save() error = %v, want %v", err, test.wantErr)
+			}
+
+			rejected := make([]models.Podcast, len(result.Rejected))
+			for i, r := range result.Rejected {
+				if !errors.Is(r.Err, errRow) {
+					t.Errorf("podcast %d rejected with %v, want the row error", *r.Podcast.ItunesID, r.Err)
+				}
+				rejected[i] = r.Podcast
+			}
+
+			if got := itunesIDs(result.Saved); fmt.Sprint(got) != fmt.Sprint(test.wantSaved) {
+				t.Errorf("Saved = %v, want %v", got, test.wantSaved)
+			}
+			if got := itunesIDs(rejected); fmt.Sprint(got) != fmt.Sprint(test.wantRejected) {
+				t.Errorf("Rejected = %v, want %v", got, test.wantRejected)
+			}
+			if got := itunesIDs(result.Unsaved); fmt.Sprint(got) != fmt.Sprint(test.wantUnsaved) {
+				t.Errorf("Unsaved = %v, want %v", got, test.wantUnsaved)
+			}
+			if result.Written != int64(len(test.wantSaved)) {
+				t.Errorf("Written = %d, want %d", result.Written, len(test.wantSaved))
+			}
+
+			if writer.attempts != test.wantAttempts {
+				t.Errorf("made %d attempts, want %d", writer.attempts, test.wantAttempts)
+			}
+			if len(writer.txs) != writer.attempts {
+				t.Errorf("made %d attempts in %d transactions, want one each", writer.attempts, len(writer.txs))
+			}
+			if retries != test.wantRetries {
+				t.Errorf("OnRetry called %d times, want %d", retries, test.wantRetries)
+			}
+
+			if got := savedIDs(t, db); fmt.Sprint(got) != fmt.Sprint(test.wantSaved) {
+				t.Errorf("database holds %v, want only the saved %v", got, test.wantSaved)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// Waits for locks instead of failing, and enforces foreign keys like Postgres
+const sqlitePragmas = "_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+
+type sqliteStore struct {
+	*gormStore
+}
+
+// Opens the SQLite database file at options.Path, creating it if it doesn't
+// exist. SQLite has a single writer, so the store uses a single connection
+func openSQLite(options Options) (Store, error) {
+	if options.Writer == service.BackendCopy {
+		return nil, errors.New("the copy persistence backend needs Postgres")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(options.Path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?%s", options.Path, sqlitePragmas)), gormConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	s, err := newGormStore(BackendSQLite, db, options)
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{s}, nil
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/migrations"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"gorm.io/gorm"
+)
+
+// Databases a store can be opened on
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// Operations the crawler runs against its database. Queries that read the
+// same on every backend go through the service package on DB instead
+type Store interface {
+	// Backend the store was opened on
+	Backend() string
+	// GORM session of the store
+	DB() *gorm.DB
+	// Applies pending schema migrations, returning the ones applied
+	Migrate() ([]migrations.Migration, error)
+	// Reverts the steps most recently applied schema migrations. Migrations
+	// that drop crawled data are only reverted when force is set
+	Rollback(steps int, force bool) ([]migrations.Migration, error)
+	// Lists schema migrations and when they were applied
+	MigrationStatus() ([]migrations.State, error)
+	// Fails when the schema isn't the one this build migrates to
+	CheckSchema() error
+	// Marks queued IDs that already have a saved podcast as done
+	MarkCrawled() (int64, error)
+	// Saves podcasts as part of crawl run runID, resolving their genres in
+	// the same transaction
+	SavePodcasts(runID string, podcasts []models.Podcast) (service.SaveResult, error)
+	// Dead letters failures and marks their IDs as failed in the crawl queue
+	RecordFailures(failures []models.DeadLetter) error
+	Close() error
+}
+
+// Settings a store is opened with
+type Options struct {
+	Backend  string
+	DSN      string // Postgres connection string or URL
+	Password string // Postgres password, overriding any password in the DSN
+	Path     string // SQLite database file
+	Pool     PoolOptions
+
+	Writer       string // Persistence backend podcasts are written with
+	SaveAttempts int
+	BackoffBase  time.Duration
+	BackoffMax   time.Duration
+}
+
+// Connection pool settings. Zero durations disable their limit
+type PoolOptions struct {
+	MaxOpenConns     int
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	ConnMaxIdleTime  time.Duration
+	ConnectTimeout   time.Duration // Also bounds the connectivity check run on open
+	StatementTimeout time.Duration // Postgres only
+}
+
+// Opens a store on the backend named in options
+func Open(options Options) (Store, error) {
+	switch options.Backend {
+	case BackendPostgres:
+		return openPostgres(options)
+	case BackendSQLite:
+		return openSQLite(options)
+	default:
+		return nil, fmt.Errorf("unknown database backend `%s`", options.Backend)
+	}
+}
+
+// What every backend shares. Backends embed it and add how they are opened
+type gormStore struct {
+	backend  string
+	db       *gorm.DB
+	migrator *migrations.Migrator
+	saver    *service.PodcastSaver
+}
+
+func newGormStore(backend string, db *gorm.DB, options Options) (*gormStore, error) {
+	writer, err := service.NewPodcastWriter(options.Writer)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := migrations.ForBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gormStore{
+		backend:  backend,
+		db:       db,
+		migrator: migrations.New(db, list),
+		saver: &service.PodcastSaver{
+			Writer:      writer,
+			Genres:      service.NewGenreCache(),
+			Attempts:    options.SaveAttempts,
+			BackoffBase: options.BackoffBase,
+			BackoffMax:  options.BackoffMax,
+			OnRetry: func(err error, attempt int, wait time.Duration) {
+				logger.Warn.Printf("Save attempt %d failed: %v. Retrying in %v\n", attempt, err, wait)
+			},
+		},
+	}, nil
+}
+
+func (s *gormStore) Backend() string {
+	return s.backend
+}
+
+func (s *gormStore) DB() *gorm.DB {
+	return s.db
+}
+
+func (s *gormStore) Migrate() ([]migrations.Migration, error) {
+	return s.migrator.Up()
+}
+
+func (s *gormStore) Rollback(steps int, force bool) ([]migrations.Migration, error) {
+	return s.migrator.Down(steps, force)
+}
+
+func (s *gormStore) MigrationStatus() ([]migrations.State, error) {
+	return s.migrator.Status()
+}
+
+func (s *gormStore) CheckSchema() error {
+	return s.migrator.Check()
+}
+
+func (s *gormStore) MarkCrawled() (int64, error) {
+	return service.MarkCrawled(s.db)
+}
+
+func (s *gormStore) SavePodcasts(runID string, podcasts []models.Podcast) (service.SaveResult, error) {
+	return s.saver.Save(s.db, runID, podcasts)
+}
+
+func (s *gormStore) RecordFailures(failures []models.DeadLetter) error {
+	return service.RecordFailures(s.db, failures)
+}
+
+func (s *gormStore) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
@@ -0,0 +1,270 @@
+package database_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/migrations"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/models"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database/service"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+const runID = "6f1c2a3e-4b5d-4e6f-8a7b-9c0d1e2f3a4b"
+
+func openSQLite(t *testing.T) database.Store {
+	t.Helper()
+
+	store, err := database.Open(database.Options{
+		Backend:      database.BackendSQLite,
+		Path:         filepath.Join(t.TempDir(), "podcasts.db"),
+		Writer:       service.BackendGorm,
+		SaveAttempts: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if _, err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func testPodcast(id uint32, name string, genres ...string) models.Podcast {
+	return service.PodcastFromItunesResult(podcast.ItunesResult{
+		CollectionId:           id,
+		CollectionName:         &name,
+		CollectionCensoredName: &name,
+		ArtistName:             "Test artist",
+		Country:                "USA",
+		PrimaryGenreName:       &genres[0],
+		Genres:                 genres,
+		Raw:                    []byte(`{"kind":"podcast"}`),
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	t.Run("Migrates an existing database again", func(t *testing.T) {
+		store := openSQLite(t)
+		applied, err := store.Migrate()
+		if err != nil {
+			t.Errorf("second Migrate() error = %v", err)
+		}
+		if len(applied) != 0 {
+			t.Errorf("second Migrate() applied %v, want nothing", applied)
+		}
+		if err := store.CheckSchema(); err != nil {
+			t.Errorf("CheckSchema() error = %v", err)
+		}
+	})
+
+	t.Run("Refuses an unmigrated database", func(t *testing.T) {
+		store, err := database.Open(database.Options{
+			Backend: database.BackendSQLite,
+			Path:    filepath.Join(t.TempDir(), "podcasts.db"),
+			Writer:  service.BackendGorm,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		if err := store.CheckSchema(); !errors.Is(err, migrations.ErrOutdated) {
+			t.Errorf("CheckSchema() = %v, want ErrOutdated", err)
+		}
+	})
+
+	t.Run("Saves podcasts with their genres and updates them in place", func(t *testing.T) {
+		store := openSQLite(t)
+
+		result, err := store.SavePodcasts(runID, []models.Podcast{
+			testPodcast(1, "First", "Comedy", "Podcasts"),
+			testPodcast(2, "Second", "News", "Podcasts"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Saved) != 2 || len(result.Rejected) != 0 || len(result.Unsaved) != 0 {
+			t.Fatalf("SavePodcasts() = %+v, want 2 saved", result)
+		}
+
+		var first models.Podcast
+		if err := store.DB().Where("itunes_id = ?", 1).First(&first).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		result, err = store.SavePodcasts(runID, []models.Podcast{testPodcast(1, "First, renamed", "Comedy")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Changes) != 1 {
+			t.Errorf("found %d changed podcasts, want 1", len(result.Changes))
+		}
+
+		var podcasts []models.Podcast
+		if err := store.DB().Order("itunes_id").Find(&podcasts).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(podcasts) != 2 {
+			t.Fatalf("found %d podcasts, want 2", len(podcasts))
+		}
+		if podcasts[0].ID != first.ID || podcasts[0].Title != "First, renamed" {
+			t.Errorf("updated podcast = %s %q, want %s %q", podcasts[0].ID, podcasts[0].Title, first.ID, "First, renamed")
+		}
+
+		var links int64
+		if err := store.DB().Model(&models.PodcastGenre{}).Where("podcast_id = ?", first.ID).Count(&links).Error; err != nil {
+			t.Fatal(err)
+		}
+		if links != 1 {
+			t.Errorf("updated podcast has %d genres, want 1", links)
+		}
+	})
+
+	t.Run("Marks queued IDs of saved podcasts as crawled", func(t *testing.T) {
+		store := openSQLite(t)
+
+		if _, err := service.EnqueueIDs(store.DB(), []uint64{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.SavePodcasts(runID, []models.Podcast{testPodcast(1, "First", "Comedy")}); err != nil {
+			t.Fatal(err)
+		}
+
+		crawled, err := store.MarkCrawled()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crawled != 1 {
+			t.Errorf("MarkCrawled() = %d, want 1", crawled)
+		}
+	})
+
+	t.Run("Pages through pending queue items", func(t *testing.T) {
+		store := openSQLite(t)
+		db := store.DB()
+
+		if _, err := service.EnqueueIDs(db, []uint64{5, 1, 4, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+		if err := service.MarkDone(db, []uint64{2}); err != nil {
+			t.Fatal(err)
+		}
+		retryAt := time.Now().Add(time.Hour)
+		if err := service.ScheduleRetry(db, []uint64{4}, retryAt); err != nil {
+			t.Fatal(err)
+		}
+
+		pending, err := service.CountPending(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending != 4 {
+			t.Errorf("CountPending() = %d, want 4", pending)
+		}
+
+		var ids []uint64
+		var after uint64
+		for {
+			page, err := service.PendingPage(db, after, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			for _, item := range page {
+				ids = append(ids, item.ItunesID)
+				if item.ItunesID == 4 && item.NextAttemptAt == nil {
+					t.Error("PendingPage() dropped the retry time of ID 4")
+				}
+			}
+			after = page[len(page)-1].ItunesID
+		}
+		if fmt.Sprint(ids) != "[1 3 4 5]" {
+			t.Errorf("PendingPage() paged through %v, want [1 3 4 5]", ids)
+		}
+	})
+
+	t.Run("Records failures", func(t *testing.T) {
+		store := openSQLite(t)
+
+		err := store.RecordFailures([]models.DeadLetter{
+			{ItunesID: 7, Category: models.FailureRejected},
+			{ItunesID: 8, Category: models.FailureUnavailable},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deadLetters, err := service.ListDeadLetters(store.DB(), models.FailureRejected, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deadLetters) != 1 || deadLetters[0].ItunesID != 7 {
+			t.Errorf("ListDeadLetters() = %+v, want only ID 7", deadLetters)
+		}
+	})
+
+	t.Run("Counts the queue and lists podcasts by filter", func(t *testing.T) {
+		store := openSQLite(t)
+
+		if _, err := service.EnqueueIDs(store.DB(), []uint64{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.SavePodcasts(runID, []models.Podcast{
+			testPodcast(1, "First", "Comedy", "Podcasts"),
+			testPodcast(2, "Second", "News", "Podcasts"),
+			testPodcast(3, "Third", "Comedy"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.MarkCrawled(); err != nil {
+			t.Fatal(err)
+		}
+
+		counts, err := service.QueueCounts(store.DB())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts[models.QueueDone] != 3 || counts[models.QueuePending] != 0 {
+			t.Errorf("QueueCounts() = %v, want 3 done", counts)
+		}
+
+		var titles []string
+		err = service.EachPodcast(store.DB(), service.StaleFilter{Genre: "Comedy"}, 1, func(podcasts []models.Podcast) error {
+			for _, p := range podcasts {
+				if p.PrimaryGenre == nil || len(p.PodcastGenres) == 0 || p.PodcastGenres[0].Genre.Name == nil {
+					t.Errorf("podcast %q was listed without its genres", p.Title)
+				}
+				titles = append(titles, p.Title)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(titles) != 2 {
+			t.Errorf("EachPodcast() listed %v, want the 2 comedy podcasts", titles)
+		}
+	})
+
+	t.Run("Refuses the copy writer", func(t *testing.T) {
+		_, err := database.Open(database.Options{
+			Backend: database.BackendSQLite,
+			Path:    filepath.Join(t.TempDir(), "podcasts.db"),
+			Writer:  service.BackendCopy,
+		})
+		if err == nil {
+			t.Error("Open() succeeded, want an error")
+		}
+	})
+}
@@ -18,6 +18,9 @@ var Error *log.Logger
 var Success *log.Logger
 var System *log.Logger
 
+// Where loggers echo their lines to, besides the log files
+var console = &colorWriter{out: os.Stdout}
+
 // Creates a file writer with predetermined options given a filename
 func createFileWriter(filename string) (io.Writer, error) {
 	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -83,11 +86,8 @@ func Init() error {
 
 	// Define logger options
 	flag := log.Ldate | log.Ltime // | log.Lshortfile
-	colorStdout := &colorWriter{
-		out: os.Stdout,
-	}
-	defaultMultiWriter := io.MultiWriter(defaultFile, colorStdout)
-	errorMultiWriter := io.MultiWriter(defaultFile, errorFile, colorStdout)
+	defaultMultiWriter := io.MultiWriter(defaultFile, console)
+	errorMultiWriter := io.MultiWriter(defaultFile, errorFile, console)
 
 	// Create loggers
 	Info = log.New(defaultMultiWriter, infoPrefix, flag)
@@ -98,3 +98,9 @@ func Init() error {
 
 	return nil
 }
+
+// Echoes log lines to stderr instead of stdout, leaving stdout to commands
+// whose output is meant to be piped
+func ConsoleToStderr() {
+	console.out = os.Stderr
+}
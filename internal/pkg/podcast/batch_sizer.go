@@ -0,0 +1,206 @@
+package podcast
+
+import (
+	"sync"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+)
+
+// Per batch size lookup statistics
+type batchSizeStats struct {
+	batches   int // Lookups made at this size
+	requested int // IDs requested across those lookups
+	returned  int // Results returned across those lookups
+	pending   int // Missing IDs whose bisection hasn't resolved yet
+	dropped   int // Missing IDs that were later found by a smaller lookup
+}
+
+type BatchSizerStats struct {
+	Size      int     // Current IDs per lookup
+	Limit     int     // Smallest size known to drop results, 0 if none has
+	Converged bool    // Whether the search for the largest safe size is over
+	Requested int     // IDs requested at the current size
+	Returned  int     // Results returned at the current size
+	DropRatio float64 // Share of requested IDs dropped at the current size
+}
+
+// Finds the largest number of IDs per lookup that iTunes answers in full.
+// IDs missing from a lookup are bisected; the ones later found by a smaller
+// lookup were dropped by the original one. A size is healthy when a window of
+// lookups made at it has all of its missing IDs resolved without any drops.
+// The sizer binary searches between the largest healthy size and the
+// smallest size known to drop results
+type BatchSizer struct {
+	mutex    sync.Mutex
+	adaptive bool
+	window   int // Lookups needed before judging a size
+	min      int
+	max      int
+
+	size  int
+	good  int // Largest size known not to drop results
+	limit int // Smallest size known to drop results, 0 if unknown
+	stats map[int]*batchSizeStats
+}
+
+func NewBatchSizer(max int, window int, adaptive bool) *BatchSizer {
+	return &BatchSizer{
+		adaptive: adaptive,
+		window:   window,
+		min:      1,
+		max:      max,
+		size:     max,
+		stats:    make(map[int]*batchSizeStats),
+	}
+}
+
+func (b *BatchSizer) Size() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.size
+}
+
+func (b *BatchSizer) statsFor(size int) *batchSizeStats {
+	s, ok := b.stats[size]
+	if !ok {
+		s = &batchSizeStats{}
+		b.stats[size] = s
+	}
+	return s
+}
+
+// Records a lookup of requested IDs that returned some results. Missing IDs
+// are expected to be resolved later through Resolve
+func (b *BatchSizer) Observe(requested int, returned int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := b.statsFor(requested)
+	s.batches++
+	s.requested += requested
+	s.returned += returned
+	if returned < requested {
+		s.pending += requested - returned
+	}
+
+	b.evaluate()
+}
+
+// Records the outcome of bisecting an ID that was missing from a lookup of
+// size IDs. found reports whether a smaller lookup returned it
+func (b *BatchSizer) Resolve(size int, found bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := b.statsFor(size)
+	if s.pending > 0 {
+		s.pending--
+	}
+	if found {
+		s.dropped++
+	}
+
+	b.evaluate()
+}
+
+// Records that a missing ID's bisection was cut short, e.g. by a failed request
+func (b *BatchSizer) Abandon(size int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := b.statsFor(size)
+	if s.pending > 0 {
+		s.pending--
+	}
+
+	b.evaluate()
+}
+
+func (b *BatchSizer) Stats() BatchSizerStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := b.statsFor(b.size)
+	stats := BatchSizerStats{
+		Size:      b.size,
+		Limit:     b.limit,
+		Converged: b.converged(),
+		Requested: s.requested,
+		Returned:  s.returned,
+	}
+	if s.requested > 0 {
+		stats.DropRatio = float64(s.dropped) / float64(s.requested)
+	}
+
+	return stats
+}
+
+func (b *BatchSizer) converged() bool {
+	return b.good == b.max || b.limit > 0 && b.limit-b.good <= 1
+}
+
+// Moves to the next size once the current one has been judged. Callers must
+// hold the mutex
+func (b *BatchSizer) evaluate() {
+	if !b.adaptive {
+		return
+	}
+
+	s := b.statsFor(b.size)
+	dropping := s.dropped > 0
+	healthy := !dropping && s.batches >= b.window && s.pending == 0
+	if !dropping && !healthy {
+		return
+	}
+
+	previous := b.size
+	if dropping {
+		b.limit = b.size
+		if b.good >= b.limit {
+			// A size that used to be healthy started dropping results
+			b.good = 0
+		}
+	} else {
+		if b.converged() {
+			return
+		}
+		b.good = b.size
+	}
+
+	if b.converged() {
+		b.size = b.good
+	} else {
+		upper := b.limit
+		if upper == 0 {
+			upper = b.max + 1
+		}
+		b.size = b.good + (upper-b.good)/2
+	}
+	if b.size < b.min {
+		b.size = b.min
+	}
+	if b.size > b.max {
+		b.size = b.max
+	}
+
+	if dropping {
+		logger.Warn.Printf(
+			"Lookups of %d IDs dropped %d/%d results. Reducing IDs per lookup to %d\n",
+			previous,
+			s.dropped,
+			s.requested,
+			b.size,
+		)
+	} else {
+		logger.Info.Printf("Lookups of %d IDs returned every available result\n", previous)
+	}
+	if b.converged() {
+		logger.Success.Printf("Learned lookup size limit: %d IDs per lookup\n", b.size)
+	} else {
+		logger.Info.Printf("Probing %d IDs per lookup\n", b.size)
+	}
+
+	// Judge the new size on fresh lookups only
+	delete(b.stats, b.size)
+}
@@ -0,0 +1,87 @@
+package podcast_test
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+func TestMain(m *testing.M) {
+	discard := log.New(io.Discard, "", 0)
+	logger.Info = discard
+	logger.Warn = discard
+	logger.Error = discard
+	logger.Success = discard
+	logger.System = discard
+
+	os.Exit(m.Run())
+}
+
+// Simulates lookups against an API that returns at most limit results per
+// lookup, with every missing ID later found by bisection
+func simulateLookups(sizer *podcast.BatchSizer, limit int, lookups int) {
+	for i := 0; i < lookups; i++ {
+		size := sizer.Size()
+		returned := size
+		if returned > limit {
+			returned = limit
+		}
+
+		sizer.Observe(size, returned)
+		for j := returned; j < size; j++ {
+			sizer.Resolve(size, true)
+		}
+	}
+}
+
+func TestBatchSizer(t *testing.T) {
+	t.Run("Converges on the largest size that doesn't drop results", func(t *testing.T) {
+		sizer := podcast.NewBatchSizer(100, 3, true)
+		simulateLookups(sizer, 35, 200)
+
+		stats := sizer.Stats()
+		if !stats.Converged {
+			t.Fatalf("Expected sizer to converge, but it is still probing %d IDs per lookup", stats.Size)
+		}
+		if stats.Size != 35 {
+			t.Errorf("Expected learned size 35, but got %d", stats.Size)
+		}
+	})
+
+	t.Run("Keeps the maximum size when unavailable IDs are the only ones missing", func(t *testing.T) {
+		sizer := podcast.NewBatchSizer(100, 3, true)
+		for i := 0; i < 10; i++ {
+			sizer.Observe(100, 60)
+			for j := 0; j < 40; j++ {
+				sizer.Resolve(100, false)
+			}
+		}
+
+		stats := sizer.Stats()
+		if stats.Size != 100 || !stats.Converged {
+			t.Errorf("Expected converged size 100, but got %d (converged: %v)", stats.Size, stats.Converged)
+		}
+	})
+
+	t.Run("Doesn't judge a size while missing IDs are unresolved", func(t *testing.T) {
+		sizer := podcast.NewBatchSizer(100, 1, true)
+		sizer.Observe(100, 35)
+
+		if size := sizer.Size(); size != 100 {
+			t.Errorf("Expected size to stay at 100, but got %d", size)
+		}
+	})
+
+	t.Run("Keeps a fixed size when not adaptive", func(t *testing.T) {
+		sizer := podcast.NewBatchSizer(100, 3, false)
+		simulateLookups(sizer, 35, 50)
+
+		if size := sizer.Size(); size != 100 {
+			t.Errorf("Expected size to stay at 100, but got %d", size)
+		}
+	})
+}
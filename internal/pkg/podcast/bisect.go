@@ -0,0 +1,15 @@
+package podcast
+
+// Splits ids into two halves to be looked up separately. Returns a single
+// group when there is only one ID left to check
+func Bisect(ids []uint64) [][]uint64 {
+	if len(ids) == 0 {
+		return [][]uint64{}
+	}
+	if len(ids) == 1 {
+		return [][]uint64{ids}
+	}
+
+	middle := len(ids) / 2
+	return [][]uint64{ids[:middle], ids[middle:]}
+}
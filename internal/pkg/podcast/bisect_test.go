@@ -0,0 +1,46 @@
+package podcast_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+func TestBisect(t *testing.T) {
+	tests := []struct {
+		title string
+		input []uint64
+		want  [][]uint64
+	}{
+		{
+			title: "Even number of IDs is split into equal halves",
+			input: []uint64{1, 2, 3, 4},
+			want:  [][]uint64{{1, 2}, {3, 4}},
+		},
+		{
+			title: "Odd number of IDs puts the extra ID in the second half",
+			input: []uint64{1, 2, 3},
+			want:  [][]uint64{{1}, {2, 3}},
+		},
+		{
+			title: "Single ID is returned as its own group",
+			input: []uint64{1},
+			want:  [][]uint64{{1}},
+		},
+		{
+			title: "Empty input returns no groups",
+			input: []uint64{},
+			want:  [][]uint64{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			result := podcast.Bisect(test.input)
+			if !reflect.DeepEqual(result, test.want) {
+				t.Errorf("Input: %v, got: %v, want: %v", test.input, result, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package podcast
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+)
+
+type FetchOutcome int
+
+const (
+	OutcomeSuccess FetchOutcome = iota
+	OutcomeError
+	OutcomeThrottled
+	OutcomeTimeout
+)
+
+type ConcurrencyOptions struct {
+	Initial       int
+	Min           int
+	Max           int
+	Increase      int           // Added to the limit after a healthy window
+	Decrease      float64       // Multiplies the limit after a throttled window
+	TargetLatency time.Duration // Average latency above which the limit stops growing
+	MaxErrorRate  float64       // Share of failed requests above which the limit stops growing
+}
+
+// Additive-increase/multiplicative-decrease controller for the number of
+// requests in flight. Outcomes are collected over a window and judged by
+// Decide: throttling or timeouts cut the limit, healthy error rates and
+// latency raise it, anything in between holds it
+type ConcurrencyController struct {
+	mutex   sync.Mutex
+	options ConcurrencyOptions
+	limit   int
+
+	requests  int
+	errors    int
+	throttled int
+	timeouts  int
+	latency   time.Duration
+}
+
+func NewConcurrencyController(options ConcurrencyOptions) *ConcurrencyController {
+	if options.Increase < 1 {
+		options.Increase = 1
+	}
+	if options.Decrease <= 0 || options.Decrease >= 1 {
+		options.Decrease = 0.5
+	}
+
+	limit := options.Initial
+	if limit < options.Min {
+		limit = options.Min
+	}
+	if limit > options.Max {
+		limit = options.Max
+	}
+
+	return &ConcurrencyController{
+		options: options,
+		limit:   limit,
+	}
+}
+
+func (c *ConcurrencyController) Limit() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.limit
+}
+
+func (c *ConcurrencyController) Record(outcome FetchOutcome, latency time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.requests++
+	c.latency += latency
+	switch outcome {
+	case OutcomeError:
+		c.errors++
+	case OutcomeThrottled:
+		c.throttled++
+	case OutcomeTimeout:
+		c.timeouts++
+	}
+}
+
+// Judges the outcomes recorded since the last decision, adjusts the limit
+// and starts a new window. Returns the new limit
+func (c *ConcurrencyController) Decide() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.requests == 0 {
+		return c.limit
+	}
+
+	previous := c.limit
+	averageLatency := c.latency / time.Duration(c.requests)
+	errorRate := float64(c.errors) / float64(c.requests)
+
+	decision := "held"
+	if c.throttled > 0 || c.timeouts > 0 {
+		c.limit = int(float64(c.limit) * c.options.Decrease)
+		if c.limit < c.options.Min {
+			c.limit = c.options.Min
+		}
+		decision = "decreased"
+	} else if errorRate <= c.options.MaxErrorRate && averageLatency <= c.options.TargetLatency {
+		c.limit += c.options.Increase
+		if c.limit > c.options.Max {
+			c.limit = c.options.Max
+		}
+		decision = "increased"
+	}
+
+	logger.Info.Printf(
+		"Concurrency %s from %d to %d (%d requests, %d errors, %d throttled, %d timeouts, %v average latency)\n",
+		decision,
+		previous,
+		c.limit,
+		c.requests,
+		c.errors,
+		c.throttled,
+		c.timeouts,
+		averageLatency.Round(time.Millisecond),
+	)
+
+	c.requests, c.errors, c.throttled, c.timeouts, c.latency = 0, 0, 0, 0, 0
+	return c.limit
+}
@@ -0,0 +1,98 @@
+package podcast_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+func newTestController() *podcast.ConcurrencyController {
+	return podcast.NewConcurrencyController(podcast.ConcurrencyOptions{
+		Initial:       10,
+		Min:           2,
+		Max:           12,
+		Increase:      1,
+		Decrease:      0.5,
+		TargetLatency: time.Second,
+		MaxErrorRate:  0.1,
+	})
+}
+
+func TestConcurrencyController(t *testing.T) {
+	tests := []struct {
+		title    string
+		outcomes []podcast.FetchOutcome
+		latency  time.Duration
+		want     int
+	}{
+		{
+			title:    "Healthy window increases the limit",
+			outcomes: []podcast.FetchOutcome{podcast.OutcomeSuccess, podcast.OutcomeSuccess},
+			latency:  100 * time.Millisecond,
+			want:     11,
+		},
+		{
+			title:    "Throttled window cuts the limit",
+			outcomes: []podcast.FetchOutcome{podcast.OutcomeSuccess, podcast.OutcomeThrottled},
+			latency:  100 * time.Millisecond,
+			want:     5,
+		},
+		{
+			title:    "Timeouts cut the limit",
+			outcomes: []podcast.FetchOutcome{podcast.OutcomeTimeout},
+			latency:  100 * time.Millisecond,
+			want:     5,
+		},
+		{
+			title:    "Slow responses hold the limit",
+			outcomes: []podcast.FetchOutcome{podcast.OutcomeSuccess},
+			latency:  2 * time.Second,
+			want:     10,
+		},
+		{
+			title:    "High error rate holds the limit",
+			outcomes: []podcast.FetchOutcome{podcast.OutcomeSuccess, podcast.OutcomeError},
+			latency:  100 * time.Millisecond,
+			want:     10,
+		},
+		{
+			title:    "Empty window holds the limit",
+			outcomes: []podcast.FetchOutcome{},
+			want:     10,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			controller := newTestController()
+			for _, outcome := range test.outcomes {
+				controller.Record(outcome, test.latency)
+			}
+
+			result := controller.Decide()
+			if result != test.want {
+				t.Errorf("got: %d, want: %d", result, test.want)
+			}
+		})
+	}
+
+	t.Run("Limit stays within the floor and ceiling", func(t *testing.T) {
+		controller := newTestController()
+		for i := 0; i < 10; i++ {
+			controller.Record(podcast.OutcomeSuccess, 0)
+			controller.Decide()
+		}
+		if limit := controller.Limit(); limit != 12 {
+			t.Errorf("Expected limit to stop at the ceiling of 12, but got %d", limit)
+		}
+
+		for i := 0; i < 10; i++ {
+			controller.Record(podcast.OutcomeThrottled, 0)
+			controller.Decide()
+		}
+		if limit := controller.Limit(); limit != 2 {
+			t.Errorf("Expected limit to stop at the floor of 2, but got %d", limit)
+		}
+	})
+}
@@ -1,24 +1,29 @@
 package podcast
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/ratelimit"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/structures"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
 )
 
+// How often an idle fetcher checks for delayed IDs becoming ready
+const idleInterval = time.Second
+
 type FetcherCommand int
 
 const (
 	Stop FetcherCommand = iota
-	Pause
-	Resume
 )
 
 type FetchResponseData struct {
@@ -33,28 +38,91 @@ type FetchResponse struct {
 	Data        FetchResponseData
 }
 
+type FetcherOptions struct {
+	// Client used for lookups. Defaults to http.DefaultClient
+	Client *http.Client
+	// Sent with every lookup when set
+	UserAgent string
+	// Deadline for a single lookup, including reading its body. 0 for none
+	RequestTimeout time.Duration
+	// Lookup URL prefix ending in `&id=` that IDs are appended to. Defaults to
+	// PODCAST_LOOKUP_URL_BASE
+	BaseUrl string
+
+	Concurrency      *ConcurrencyController
+	DecisionInterval time.Duration // How often Concurrency judges recent requests
+	Sizer            *BatchSizer
+	Limiter          ratelimit.Limiter
+
+	// Called for more IDs whenever the pool runs out, so IDs can be read a
+	// page at a time. Returning none means there are no more. Optional
+	Refill func() ([]uint64, error)
+}
+
 type Fetcher struct {
-	idPool            structures.Pool[uint64]
-	concurrentFetches int
-	maxIdsPerFetch    int
+	idPool           structures.Pool[uint64]
+	groups           structures.Pool[[]uint64] // ID sets that are looked up as a single URL each
+	delayedIds       structures.DelayPool[uint64]
+	client           *http.Client
+	userAgent        string
+	requestTimeout   time.Duration
+	baseUrl          string
+	concurrency      *ConcurrencyController
+	decisionInterval time.Duration
+	sizer            *BatchSizer
+	limiter          ratelimit.Limiter
+	refill           func() ([]uint64, error) // nil once it has run out of IDs
+
+	// runCtx governs the whole run and cancels in-flight requests when done.
+	// ctx is derived from it and only stops new requests from being issued
+	runCtx   context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inFlight atomic.Int64
+	issued   atomic.Int64  // Lookups fired since the fetcher was created
+	released chan struct{} // Signalled whenever a request completes
 
-	ticker          *time.Ticker
-	lastFetchEnd    time.Time
 	CommandChannel  chan FetcherCommand
 	ResponseChannel chan FetchResponse
+	DrainedChannel  chan struct{} // Signalled whenever the fetcher runs out of IDs with no requests in flight
+	StoppedChannel  chan struct{} // Closed once the fetcher has stopped and no requests are in flight
 	fetchWaitGroup  sync.WaitGroup
 
-	pause bool
+	// Called with the IDs of every batch right before its requests are fired
+	OnBatch func(ids []uint64)
 }
 
 func (f *Fetcher) Append(ids ...uint64) {
 	f.idPool.Put(ids...)
 }
 
+// Queues ID sets to be looked up one URL per set, ahead of the ID pool. Unlike
+// pool IDs, grouped IDs are not reported through OnBatch
+func (f *Fetcher) AppendGroups(groups ...[]uint64) {
+	f.groups.Put(groups...)
+}
+
+// Adds ids to the pool once readyAt has passed
+func (f *Fetcher) Delay(readyAt time.Time, ids ...uint64) {
+	f.delayedIds.Put(readyAt, ids...)
+}
+
 func (f *Fetcher) Shuffle() {
 	f.idPool.Shuffle()
 }
 
+// Returns the number of IDs and bisection groups waiting to be fetched,
+// including delayed IDs
+func (f *Fetcher) Length() int {
+	return f.idPool.Length() + f.groups.Length() + f.delayedIds.Length()
+}
+
+// Returns the number of lookups fired so far. Each one delivers exactly one
+// response on ResponseChannel
+func (f *Fetcher) Issued() int64 {
+	return f.issued.Load()
+}
+
 func NewFetchResponse(
 	success bool,
 	status int,
@@ -73,149 +141,338 @@ func NewFetchResponse(
 	}
 }
 
-func NewFetcher(ids []uint64, concurrentFetches int, maxIdsPerFetch int) *Fetcher {
-	seconds := time.Duration(3) // Approximates the iTunes API rate limit (20 calls/minute)
-	t := time.NewTicker(seconds * time.Second)
-	logger.Info.Printf("Ticker created, fires every %d seconds\n", seconds)
+// Creates a fetcher for ids. Cancelling ctx stops the fetcher and aborts its
+// in-flight requests, while Stop lets them finish
+func NewFetcher(ctx context.Context, ids []uint64, options FetcherOptions) *Fetcher {
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.BaseUrl == "" {
+		options.BaseUrl = PODCAST_LOOKUP_URL_BASE
+	}
 
-	f := &Fetcher{
-		idPool:            structures.CreatePool[uint64](ids),
-		concurrentFetches: concurrentFetches,
-		maxIdsPerFetch:    maxIdsPerFetch,
+	dispatchCtx, cancel := context.WithCancel(ctx)
 
-		ticker:       t,
-		lastFetchEnd: utils.TimeUnixEpochStart,
+	f := &Fetcher{
+		idPool:           structures.CreatePool[uint64](ids),
+		groups:           structures.CreatePool[[]uint64]([][]uint64{}),
+		delayedIds:       structures.CreateDelayPool[uint64](),
+		client:           options.Client,
+		userAgent:        options.UserAgent,
+		requestTimeout:   options.RequestTimeout,
+		baseUrl:          options.BaseUrl,
+		concurrency:      options.Concurrency,
+		decisionInterval: options.DecisionInterval,
+		sizer:            options.Sizer,
+		limiter:          options.Limiter,
+		refill:           options.Refill,
+
+		runCtx:   ctx,
+		ctx:      dispatchCtx,
+		cancel:   cancel,
+		released: make(chan struct{}, 1),
 
 		CommandChannel:  make(chan FetcherCommand),
 		ResponseChannel: make(chan FetchResponse),
+		DrainedChannel:  make(chan struct{}, 1),
+		StoppedChannel:  make(chan struct{}),
 		fetchWaitGroup:  sync.WaitGroup{},
 	}
 
 	logger.Info.Printf(
-		"Podcast fetcher created with a pool of %d IDs\n",
+		"Podcast fetcher created with a pool of %d IDs and %d concurrent requests\n",
 		len(ids),
+		f.concurrency.Limit(),
 	)
 
 	return f
 }
 
+// Sends a lookup request bound to the run context and the request deadline.
+// The returned cancel function must be called once the body has been read
+func (f *Fetcher) get(url string) (*http.Response, context.CancelFunc, error) {
+	ctx, cancel := f.runCtx, context.CancelFunc(func() {})
+	if f.requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, f.requestTimeout)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, cancel, err
+	}
+	if f.userAgent != "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+
+	resp, err := f.client.Do(req)
+	return resp, cancel, err
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Performs a single lookup request and delivers the result on ResponseChannel.
+// The request is only considered done once its response has been received
+// by the consumer, so waiting on fetchWaitGroup also waits for delivery and
+// a slow consumer holds on to slots, holding back new lookups
 func (f *Fetcher) fetch(url string) {
-	resp, err := http.Get(url)
-	f.fetchWaitGroup.Done()
+	defer f.fetchWaitGroup.Done()
+	defer f.releaseSlot()
+
+	start := time.Now()
+	outcome := OutcomeSuccess
+	defer func() {
+		f.concurrency.Record(outcome, time.Since(start))
+	}()
+
+	resp, cancel, err := f.get(url)
+	defer cancel()
 
 	statusCode := 500
 	if resp != nil {
 		statusCode = resp.StatusCode
 	}
 
+	if isTimeout(err) {
+		outcome = OutcomeTimeout
+	} else if err != nil || statusCode != 200 {
+		outcome = OutcomeError
+	}
+
+	if statusCode == http.StatusTooManyRequests || statusCode == http.StatusForbidden {
+		outcome = OutcomeThrottled
+		retryAfter := ratelimit.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		f.limiter.Throttle(retryAfter)
+		logger.Warn.Printf(
+			"Throttled with status %d (Retry-After: %v). Slowing down to %.1f requests/minute\n",
+			statusCode,
+			retryAfter,
+			f.limiter.Rate(),
+		)
+	}
+
 	if err != nil || statusCode != 200 {
-		go func() {
-			f.ResponseChannel <- NewFetchResponse(
-				false,
-				statusCode,
-				true,
-				url,
-				"",
-			)
-		}()
+		if resp != nil {
+			resp.Body.Close()
+		}
+		f.ResponseChannel <- NewFetchResponse(
+			false,
+			statusCode,
+			true,
+			url,
+			"",
+		)
 		return
 	}
 
+	f.limiter.Succeed()
+
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		go func() {
-			f.ResponseChannel <- NewFetchResponse(
-				false,
-				statusCode,
-				false,
-				url,
-				"",
-			)
-		}()
-		return
-	}
-
-	go func() {
+		outcome = OutcomeError
+		if isTimeout(err) {
+			outcome = OutcomeTimeout
+		}
 		f.ResponseChannel <- NewFetchResponse(
-			true,
+			false,
 			statusCode,
-			true,
+			false,
 			url,
-			string(body),
+			"",
 		)
-	}()
+		return
+	}
+
+	f.ResponseChannel <- NewFetchResponse(
+		true,
+		statusCode,
+		true,
+		url,
+		string(body),
+	)
 }
 
-func (f *Fetcher) onTick(t time.Time) {
-	logger.Info.Println("Pulse at:", t)
+// Whether there are IDs or bisection groups ready to be looked up. Moves
+// delayed IDs whose backoff is over into the pool, and refills the pool once
+// it runs out
+func (f *Fetcher) hasWork() bool {
+	readyIds := f.delayedIds.TakeReady(time.Now())
+	if len(readyIds) > 0 {
+		logger.Info.Printf("%d delayed IDs are ready to be retried\n", len(readyIds))
+		f.idPool.Put(readyIds...)
+		f.idPool.Shuffle()
+	}
 
-	if f.pause {
-		logger.Info.Println("Fetcher paused. No actions performed this pulse.")
-		return
+	if f.idPool.Length() == 0 && f.refill != nil {
+		f.refillPool()
 	}
 
-	tresholdSeconds := 3
-	timeSinceFetchEnd := t.Sub(f.lastFetchEnd)
-	if timeSinceFetchEnd.Seconds() < float64(tresholdSeconds) {
-		logger.Warn.Printf("Request within %d seconds avoided to keep from hitting iTunes rate limits. Pulse ended.\n", tresholdSeconds)
+	return f.idPool.Length() > 0 || f.groups.Length() > 0
+}
+
+// Adds the IDs returned by refill to the pool. The fetcher stops when they
+// can't be read, since it would otherwise report being drained while IDs
+// remain
+func (f *Fetcher) refillPool() {
+	ids, err := f.refill()
+	if err != nil {
+		logger.Error.Printf("Failed to read more IDs to look up: %v. Stopping fetcher\n", err)
+		f.cancel()
 		return
 	}
+	if len(ids) == 0 {
+		f.refill = nil
+		return
+	}
+
+	f.idPool.Put(ids...)
+}
 
-	if f.idPool.Length() == 0 {
-		logger.Success.Println("Done crawling IDs")
-		os.Exit(0)
+// Builds the next lookup URL. Bisection groups take priority over the pool
+func (f *Fetcher) next() (string, bool) {
+	groups := f.groups.Take(1)
+	if len(groups) > 0 {
+		return f.baseUrl + utils.JoinNumbers(groups[0], ","), true
 	}
 
-	batch := f.idPool.Take(f.concurrentFetches * f.maxIdsPerFetch)
+	batch := f.idPool.Take(f.sizer.Size())
+	if len(batch) == 0 {
+		return "", false
+	}
+	if f.OnBatch != nil {
+		f.OnBatch(batch)
+	}
 
-	urls := CreateBatchLookupUrls(
-		PODCAST_LOOKUP_URL_BASE,
-		batch,
-		f.maxIdsPerFetch,
-	)
-	logger.Info.Printf("Created %d urls from %d ids\n", len(urls), len(batch))
+	return f.baseUrl + utils.JoinNumbers(batch, ","), true
+}
 
-	logger.Info.Printf("Firing %d concurrent requests...\n", len(urls))
-	for _, url := range urls {
+// Waits until fewer requests than the concurrency limit are in flight while
+// handling commands, then takes a slot. Returns false if the fetcher was
+// stopped in the meantime
+func (f *Fetcher) acquireSlot() bool {
+	for f.inFlight.Load() >= int64(f.concurrency.Limit()) {
+		select {
+		case <-f.released:
+		case command := <-f.CommandChannel:
+			f.onCommand(command)
+		case <-f.ctx.Done():
+			return false
+		}
+	}
+
+	f.inFlight.Add(1)
+	return true
+}
+
+func (f *Fetcher) releaseSlot() {
+	f.inFlight.Add(-1)
+	select {
+	case f.released <- struct{}{}:
+	default:
+	}
+}
+
+// Lets the concurrency controller judge the requests made every interval
+func (f *Fetcher) adjustConcurrency() {
+	ticker := time.NewTicker(f.decisionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.concurrency.Decide()
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+// Dispatches lookups until stopped, pacing them with the rate limiter and
+// keeping at most concurrentFetches requests in flight
+func (f *Fetcher) run() {
+	defer close(f.StoppedChannel)
+
+	idle := time.NewTicker(idleInterval)
+	defer idle.Stop()
+
+	for f.ctx.Err() == nil {
+		if !f.hasWork() {
+			if f.inFlight.Load() == 0 && f.delayedIds.Length() == 0 && f.ctx.Err() == nil {
+				f.notifyDrained()
+			}
+
+			select {
+			case command := <-f.CommandChannel:
+				f.onCommand(command)
+			case <-idle.C:
+			case <-f.ctx.Done():
+			}
+			continue
+		}
+
+		if !f.acquireSlot() {
+			continue
+		}
+
+		if err := f.limiter.Wait(f.ctx); err != nil {
+			f.releaseSlot()
+			continue
+		}
+
+		url, ok := f.next()
+		if !ok {
+			f.releaseSlot()
+			continue
+		}
+
+		f.issued.Add(1)
 		f.fetchWaitGroup.Add(1)
 		go f.fetch(url)
+
+		logger.System.Printf(
+			"Running goroutines: %d, requests in flight: %d/%d, rate: %.1f requests/minute\n",
+			runtime.NumGoroutine(),
+			f.inFlight.Load(),
+			f.concurrency.Limit(),
+			f.limiter.Rate(),
+		)
 	}
-	logger.Info.Printf("%d concurrent requests fired. Awaiting responses...\n", len(urls))
 
+	logger.Info.Println("Stopping fetcher. Waiting for in-flight requests...")
 	f.fetchWaitGroup.Wait()
-	logger.Info.Printf("%d concurrent requests completed\n", len(urls))
-	f.lastFetchEnd = time.Now()
+	logger.Info.Println("Fetcher stopped")
+}
+
+func (f *Fetcher) notifyDrained() {
+	select {
+	case f.DrainedChannel <- struct{}{}:
+	default:
+		// A drained signal is already pending
+	}
 }
 
 func (f *Fetcher) onCommand(command FetcherCommand) {
 	logger.Info.Printf("Command received: %d", command)
 
 	if command == Stop {
-		logger.Info.Println("Stop command received. Exitting...")
-		os.Exit(0)
-	} else if command == Pause {
-		logger.Info.Println("Pause command received")
-		f.pause = true
-	} else if command == Resume {
-		logger.Info.Println("Resume command received")
-		f.pause = false
+		logger.Info.Println("Stop command received")
+		f.cancel()
 	}
 }
 
+// Stops issuing requests. Requests already in flight still deliver their
+// responses, so the caller must keep draining ResponseChannel until
+// StoppedChannel is closed
+func (f *Fetcher) Stop() {
+	f.cancel()
+}
+
 func (f *Fetcher) Start() {
-	go func() {
-		logger.Info.Println("Podcast fetcher pulse goroutine created")
-		for {
-			select {
-			case t := <-f.ticker.C:
-				logger.System.Println("Running Goroutines:", runtime.NumGoroutine())
-				f.onTick(t)
-			case command := <-f.CommandChannel:
-				f.onCommand(command)
-			}
-		}
-	}()
+	go f.run()
+	go f.adjustConcurrency()
 
 	logger.Info.Println("Podcast fetcher started")
 }
@@ -0,0 +1,220 @@
+package podcast_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/ratelimit"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func newTestFetcher(ctx context.Context, ids []uint64, transport roundTripFunc, requestTimeout time.Duration) *podcast.Fetcher {
+	return podcast.NewFetcher(ctx, ids, testFetcherOptions(transport, requestTimeout))
+}
+
+func testFetcherOptions(transport roundTripFunc, requestTimeout time.Duration) podcast.FetcherOptions {
+	return podcast.FetcherOptions{
+		Client:         &http.Client{Transport: transport},
+		UserAgent:      "podcrawler-test",
+		RequestTimeout: requestTimeout,
+		BaseUrl:        "https://itunes.test/lookup?entity=podcast&id=",
+
+		Concurrency: podcast.NewConcurrencyController(podcast.ConcurrencyOptions{
+			Initial: 2,
+			Min:     1,
+			Max:     2,
+		}),
+		DecisionInterval: time.Minute,
+		Sizer:            podcast.NewBatchSizer(2, 1, false),
+		Limiter:          ratelimit.NewTokenBucket(6000, 10, time.Second),
+	}
+}
+
+// Collects responses until count have arrived or the timeout passes
+func collectResponses(t *testing.T, f *podcast.Fetcher, count int, timeout time.Duration) []podcast.FetchResponse {
+	t.Helper()
+
+	responses := make([]podcast.FetchResponse, 0, count)
+	deadline := time.After(timeout)
+	for len(responses) < count {
+		select {
+		case r := <-f.ResponseChannel:
+			responses = append(responses, r)
+		case <-deadline:
+			t.Fatalf("Expected %d responses, but got %d before timing out", count, len(responses))
+		}
+	}
+
+	return responses
+}
+
+func TestFetcher(t *testing.T) {
+	t.Run("Delivers lookup responses through the injected client", func(t *testing.T) {
+		var mutex sync.Mutex
+		userAgents := []string{}
+		transport := func(req *http.Request) (*http.Response, error) {
+			mutex.Lock()
+			userAgents = append(userAgents, req.Header.Get("User-Agent"))
+			mutex.Unlock()
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(`{"resultCount":0,"results":[]}`)),
+			}, nil
+		}
+
+		f := newTestFetcher(context.Background(), []uint64{1, 2, 3, 4}, transport, time.Second)
+		f.Start()
+		defer f.Stop()
+
+		responses := collectResponses(t, f, 2, 5*time.Second)
+		ids := []uint64{}
+		for _, r := range responses {
+			if !r.Success {
+				t.Errorf("Expected a successful response for %s", r.Data.Url)
+			}
+			ids = append(ids, podcast.ExtractLookupIDs(r.Data.Url)...)
+		}
+		if len(ids) != 4 {
+			t.Errorf("Expected all 4 IDs to be looked up, but got %v", ids)
+		}
+
+		select {
+		case <-f.DrainedChannel:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the fetcher to report that it's drained")
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, userAgent := range userAgents {
+			if userAgent != "podcrawler-test" {
+				t.Errorf("Expected User-Agent `podcrawler-test`, but got `%s`", userAgent)
+			}
+		}
+	})
+
+	t.Run("Cuts off hung requests at the request deadline", func(t *testing.T) {
+		transport := func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+
+		f := newTestFetcher(context.Background(), []uint64{1}, transport, 50*time.Millisecond)
+		f.Start()
+		defer f.Stop()
+
+		responses := collectResponses(t, f, 1, 5*time.Second)
+		if responses[0].Success {
+			t.Error("Expected the hung request to fail")
+		}
+	})
+
+	t.Run("Cancelling the run context aborts in-flight requests", func(t *testing.T) {
+		started := make(chan struct{})
+		transport := func(req *http.Request) (*http.Response, error) {
+			close(started)
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		f := newTestFetcher(ctx, []uint64{1}, transport, 0)
+		f.Start()
+
+		<-started
+		cancel()
+
+		responses := collectResponses(t, f, 1, 5*time.Second)
+		if responses[0].Success {
+			t.Error("Expected the aborted request to fail")
+		}
+
+		select {
+		case <-f.StoppedChannel:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the fetcher to stop once its context was cancelled")
+		}
+	})
+
+	t.Run("Refills the pool a page at a time until it runs out", func(t *testing.T) {
+		transport := func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(`{"resultCount":0,"results":[]}`)),
+			}, nil
+		}
+
+		pages := [][]uint64{{1, 2}, {3, 4}, {5}}
+		refills := 0
+		options := testFetcherOptions(transport, time.Second)
+		options.Refill = func() ([]uint64, error) {
+			refills++
+			if len(pages) == 0 {
+				return nil, nil
+			}
+			page := pages[0]
+			pages = pages[1:]
+			return page, nil
+		}
+
+		f := podcast.NewFetcher(context.Background(), nil, options)
+		f.Start()
+		defer f.Stop()
+
+		responses := collectResponses(t, f, 3, 5*time.Second)
+		ids := []uint64{}
+		for _, r := range responses {
+			ids = append(ids, podcast.ExtractLookupIDs(r.Data.Url)...)
+		}
+		if len(ids) != 5 {
+			t.Errorf("Expected all 5 paged IDs to be looked up, but got %v", ids)
+		}
+
+		select {
+		case <-f.DrainedChannel:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the fetcher to report that it's drained")
+		}
+		if refills != 4 {
+			t.Errorf("Expected 4 refills, the last one empty, but got %d", refills)
+		}
+	})
+
+	t.Run("Stops when the pool can't be refilled", func(t *testing.T) {
+		transport := func(req *http.Request) (*http.Response, error) {
+			t.Error("Expected no lookups")
+			return nil, errors.New("unexpected lookup")
+		}
+
+		options := testFetcherOptions(transport, time.Second)
+		options.Refill = func() ([]uint64, error) {
+			return nil, errors.New("database is gone")
+		}
+
+		f := podcast.NewFetcher(context.Background(), nil, options)
+		f.Start()
+
+		select {
+		case <-f.StoppedChannel:
+		case <-f.DrainedChannel:
+			t.Fatal("Expected the fetcher to stop rather than report being drained")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the fetcher to stop")
+		}
+	})
+}
@@ -1,30 +1,480 @@
 package podcast
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
-	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 )
 
-func loadInputFile(filename string) ([]string, error) {
-	content, err := os.ReadFile(filename)
+// Formats inputs can be read in
+const (
+	InputAuto  = "auto"  // Detected from the file extension, then the content
+	InputText  = "text"  // One ID or podcast url per line
+	InputCSV   = "csv"   // IDs or urls in one column
+	InputJSONL = "jsonl" // One object, ID or url per line. A JSON array also works
+	InputOPML  = "opml"  // Podcast urls in outline attributes
+)
+
+// Bytes looked at to detect the format and compression of an input
+const sniffSize = 64 * 1024
+
+// Longest line a text input may have
+const maxLineSize = 1024 * 1024
+
+// Where to read input IDs from and how
+type InputOptions struct {
+	Path    string // File to read, or - for stdin
+	Format  string // One of the Input* formats. Empty is the same as InputAuto
+	IDField string // CSV column, JSON field or OPML attribute to read. Found by name when empty
+}
+
+// A stream of iTunes IDs read from an input
+type InputSource interface {
+	// Returns the next ID, or io.EOF once the input is exhausted
+	Next() (uint64, error)
+	// Format the input is read as
+	Format() string
+	// Entries read so far that held no iTunes ID
+	Skipped() int
+	Close() error
+}
+
+// Reads the values of each entry that may hold an ID or podcast url, in the
+// order they are tried
+type entryReader interface {
+	next() ([]string, error)
+}
+
+// Names of columns, fields and attributes that hold IDs or urls, normalized
+// with fieldKey. Earlier names are preferred
+var idFields = []string{
+	"itunesid",
+	"collectionid",
+	"podcastid",
+	"trackid",
+	"id",
+	"itunesurl",
+	"applepodcastsurl",
+	"podcasturl",
+	"collectionviewurl",
+	"trackviewurl",
+	"htmlurl",
+	"url",
+	"xmlurl",
+}
+
+// Lowercases name and drops separators, so itunes_id, iTunesId and
+// "iTunes ID" all match
+func fieldKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '_', '-', ' ', '.':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(name)))
+}
+
+type inputSource struct {
+	entries entryReader
+	format  string
+	skipped int
+	closers []io.Closer
+}
+
+// Opens the input described by options. Gzip and zstd compressed inputs are
+// decompressed as they are read, whatever their name
+func OpenInput(options InputOptions) (InputSource, error) {
+	s := &inputSource{}
+
+	var in io.Reader
+	if options.Path == "-" {
+		in = os.Stdin
+	} else {
+		file, err := os.Open(options.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, file)
+		in = file
+	}
+
+	r, err := s.decompress(bufio.NewReaderSize(in, sniffSize))
 	if err != nil {
+		s.Close()
 		return nil, err
 	}
 
-	contentLines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	s.format = options.Format
+	if s.format == "" || s.format == InputAuto {
+		s.format = detectFormat(options.Path, r)
+	}
+
+	switch s.format {
+	case InputText:
+		s.entries = newTextReader(r)
+	case InputCSV:
+		s.entries, err = newCSVReader(r, options.IDField)
+	case InputJSONL:
+		s.entries, err = newJSONReader(r, options.IDField)
+	case InputOPML:
+		s.entries = newOPMLReader(r, options.IDField)
+	default:
+		err = fmt.Errorf("unknown input format `%s`", s.format)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Wraps r in a decompressor if it starts with a gzip or zstd header. The
+// returned reader starts past any UTF-8 byte order mark
+func (s *inputSource) decompress(r *bufio.Reader) (*bufio.Reader, error) {
+	magic, _ := r.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, gz)
+		r = bufio.NewReaderSize(gz, sniffSize)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, zr.IOReadCloser())
+		r = bufio.NewReaderSize(zr, sniffSize)
+	}
+
+	if bom, _ := r.Peek(3); bytes.Equal(bom, []byte{0xef, 0xbb, 0xbf}) {
+		r.Discard(3)
+	}
+
+	return r, nil
+}
+
+// Picks a format by the extension of path, ignoring compression extensions.
+// Inputs without a known extension, like stdin, are recognized by their
+// first bytes
+func detectFormat(path string, r *bufio.Reader) string {
+	name := strings.ToLower(filepath.Base(path))
+	for _, ext := range []string{".gz", ".gzip", ".zst", ".zstd"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	switch filepath.Ext(name) {
+	case ".txt":
+		return InputText
+	case ".csv":
+		return InputCSV
+	case ".jsonl", ".ndjson", ".json":
+		return InputJSONL
+	case ".opml", ".xml":
+		return InputOPML
+	}
+
+	switch firstByte(r) {
+	case '<':
+		return InputOPML
+	case '{', '[':
+		return InputJSONL
+	}
+
+	head, _ := r.Peek(sniffSize)
+	firstLine, _, _ := bytes.Cut(head, []byte("\n"))
+	if bytes.Contains(firstLine, []byte(",")) {
+		return InputCSV
+	}
+	return InputText
+}
+
+// First byte of r that isn't whitespace, without consuming it. 0 when there
+// is none within sniffSize bytes
+func firstByte(r *bufio.Reader) byte {
+	head, _ := r.Peek(sniffSize)
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if len(head) == 0 {
+		return 0
+	}
+
+	return head[0]
+}
+
+func (s *inputSource) Next() (uint64, error) {
+	for {
+		values, err := s.entries.next()
+		if err != nil {
+			return 0, err
+		}
+
+		if id, ok := firstID(values); ok {
+			return id, nil
+		}
+		s.skipped++
+	}
+}
+
+// Returns the first value that is an ID or holds one in a url
+func firstID(values []string) (uint64, bool) {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if id, err := ParseID(value); err == nil {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+func (s *inputSource) Format() string {
+	return s.format
+}
+
+func (s *inputSource) Skipped() int {
+	return s.skipped
+}
+
+// Closes decompressors before the file they read from
+func (s *inputSource) Close() error {
+	errs := make([]error, 0, len(s.closers))
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		errs = append(errs, s.closers[i].Close())
+	}
+	s.closers = nil
+
+	return errors.Join(errs...)
+}
+
+// Lines holding an ID or url. Blank lines and lines starting with # are
+// ignored
+type textReader struct {
+	scanner *bufio.Scanner
+}
+
+func newTextReader(r io.Reader) *textReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	return &textReader{scanner: scanner}
+}
+
+func (t *textReader) next() ([]string, error) {
+	for t.scanner.Scan() {
+		line := strings.TrimSpace(t.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return []string{line}, nil
+	}
 
-	logger.Info.Printf("Loaded %d URLs from file: `%s`\n", len(contentLines), filename)
-	return contentLines, nil
+	if err := t.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Rows of a CSV file. The ID column is named by idField or found among the
+// header's columns. Files without a header use the first column holding an
+// ID in their first row
+type csvReader struct {
+	reader *csv.Reader
+	column int
+	first  []string // First row, when it isn't a header
 }
 
-func GetIDs() ([]uint64, error) {
-	urls, err := loadInputFile(config.AppConfig.PodcastListFile)
+func newCSVReader(r io.Reader, idField string) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	reader.TrimLeadingSpace = true
+
+	c := &csvReader{reader: reader}
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return c, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return extractIDs(urls), nil
+	if idField != "" {
+		c.column = fieldIndex(header, []string{fieldKey(idField)})
+		if c.column < 0 {
+			return nil, fmt.Errorf("the CSV header has no `%s` column", idField)
+		}
+		return c, nil
+	}
+
+	if c.column = fieldIndex(header, idFields); c.column >= 0 {
+		return c, nil
+	}
+	for i, value := range header {
+		if _, ok := firstID([]string{value}); ok {
+			c.column = i
+			c.first = append([]string(nil), header...)
+			return c, nil
+		}
+	}
+
+	return nil, errors.New("unable to tell which CSV column holds IDs. Name it with inputIdField")
+}
+
+// Index of the first of names found in header, or -1
+func fieldIndex(header []string, names []string) int {
+	for _, name := range names {
+		for i, column := range header {
+			if fieldKey(column) == name {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func (c *csvReader) next() ([]string, error) {
+	row := c.first
+	c.first = nil
+	if row == nil {
+		var err error
+		if row, err = c.reader.Read(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.column >= len(row) {
+		return nil, nil
+	}
+	return []string{row[c.column]}, nil
+}
+
+// JSON values, one after another or in a single array. Objects are read for
+// idField, or their fields among idFields. Strings and numbers are read as
+// they are
+type jsonReader struct {
+	decoder *json.Decoder
+	idField string
+	inArray bool
+}
+
+// Steps into a top level array, so its elements are decoded one at a time
+// instead of all at once
+func newJSONReader(r *bufio.Reader, idField string) (*jsonReader, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	j := &jsonReader{decoder: decoder, idField: idField}
+	if firstByte(r) == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		j.inArray = true
+	}
+
+	return j, nil
+}
+
+func (j *jsonReader) next() ([]string, error) {
+	if j.inArray && !j.decoder.More() {
+		return nil, io.EOF
+	}
+
+	var value interface{}
+	if err := j.decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return []string{jsonText(value)}, nil
+	}
+
+	names := idFields
+	if j.idField != "" {
+		names = []string{fieldKey(j.idField)}
+	}
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		for key, field := range object {
+			if fieldKey(key) == name {
+				values = append(values, jsonText(field))
+			}
+		}
+	}
+
+	return values, nil
+}
+
+func jsonText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
+
+// Outline elements of an OPML document. Each is read for the idField
+// attribute, or its attributes among idFields. Outlines without any of them,
+// like folders, are passed over
+type opmlReader struct {
+	decoder *xml.Decoder
+	idField string
+}
+
+func newOPMLReader(r io.Reader, idField string) *opmlReader {
+	return &opmlReader{decoder: xml.NewDecoder(r), idField: idField}
+}
+
+func (o *opmlReader) next() ([]string, error) {
+	names := idFields
+	if o.idField != "" {
+		names = []string{fieldKey(o.idField)}
+	}
+
+	for {
+		token, err := o.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok || !strings.EqualFold(element.Name.Local, "outline") {
+			continue
+		}
+
+		values := make([]string, 0, len(names))
+		for _, name := range names {
+			for _, attr := range element.Attr {
+				if fieldKey(attr.Name.Local) == name {
+					values = append(values, attr.Value)
+				}
+			}
+		}
+		if len(values) > 0 {
+			return values, nil
+		}
+	}
 }
@@ -0,0 +1,265 @@
+package podcast_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipped(t *testing.T, content string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	w := gzip.NewWriter(&buffer)
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func zstdCompressed(t *testing.T, content string) []byte {
+	t.Helper()
+
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	return w.EncodeAll([]byte(content), nil)
+}
+
+// Reads every ID of the input file name holding content
+func readInput(t *testing.T, name string, content []byte, options podcast.InputOptions) ([]uint64, podcast.InputSource) {
+	t.Helper()
+
+	options.Path = filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(options.Path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := podcast.OpenInput(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { source.Close() })
+
+	ids := make([]uint64, 0)
+	for {
+		id, err := source.Next()
+		if errors.Is(err, io.EOF) {
+			return ids, source
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+}
+
+const testOPML = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="News">
+      <outline type="rss" text="First" xmlUrl="https://feeds.example.com/first.xml" htmlUrl="https://podcasts.apple.com/us/podcast/first/id101"/>
+      <outline type="rss" text="Second" xmlUrl="https://feeds.example.com/second/id0755" htmlUrl="https://example.com/podcasts/id102"/>
+    </outline>
+    <outline type="rss" text="Third" itunesId="103" xmlUrl="https://feeds.example.com/third.xml"/>
+  </body>
+</opml>`
+
+func TestOpenInput(t *testing.T) {
+	tests := []struct {
+		title       string
+		name        string
+		content     []byte
+		options     podcast.InputOptions
+		wantFormat  string
+		wantIDs     []uint64
+		wantSkipped int
+	}{
+		{
+			title:       "Text with IDs, urls, comments and blank lines",
+			name:        "podcasts.txt",
+			content:     []byte("# Podcasts\n101\n\nhttps://podcasts.apple.com/us/podcast/second/id102?i=1\r\nnot a podcast\n103\n"),
+			wantFormat:  podcast.InputText,
+			wantIDs:     []uint64{101, 102, 103},
+			wantSkipped: 1,
+		},
+		{
+			title:      "CSV with an ID column found by name",
+			name:       "export.csv",
+			content:    []byte("title,iTunes ID,year\n\"First, the show\",101,2019\nSecond,102,2020\n"),
+			wantFormat: podcast.InputCSV,
+			wantIDs:    []uint64{101, 102},
+		},
+		{
+			title:      "CSV without a header",
+			name:       "export.csv",
+			content:    []byte("First,101,2019\nSecond,102,2020\n"),
+			wantFormat: podcast.InputCSV,
+			wantIDs:    []uint64{101, 102},
+		},
+		{
+			title:       "CSV with a configured column",
+			name:        "export.csv",
+			content:     []byte("id,link\n1,https://podcasts.apple.com/us/podcast/first/id101\n2,\n"),
+			options:     podcast.InputOptions{IDField: "link"},
+			wantFormat:  podcast.InputCSV,
+			wantIDs:     []uint64{101},
+			wantSkipped: 1,
+		},
+		{
+			title:       "JSON Lines of objects and plain values",
+			name:        "dump.jsonl",
+			content:     []byte("{\"collectionId\": 101, \"trackName\": \"First\"}\n{\"trackViewUrl\": \"https://podcasts.apple.com/us/podcast/second/id102\"}\n\"103\"\n104\n{\"title\": \"No ID\"}\n"),
+			wantFormat:  podcast.InputJSONL,
+			wantIDs:     []uint64{101, 102, 103, 104},
+			wantSkipped: 1,
+		},
+		{
+			title:      "JSON array",
+			name:       "dump.json",
+			content:    []byte(`[{"itunes_id": 101}, {"itunes_id": 102}]`),
+			wantFormat: podcast.InputJSONL,
+			wantIDs:    []uint64{101, 102},
+		},
+		{
+			title:      "JSON objects falling back to a later field",
+			name:       "dump.jsonl",
+			content:    []byte("{\"id\": \"abc\", \"url\": \"https://podcasts.apple.com/us/podcast/first/id101\"}\n"),
+			wantFormat: podcast.InputJSONL,
+			wantIDs:    []uint64{101},
+		},
+		{
+			title:       "OPML outlines, skipping folders",
+			name:        "subscriptions.opml",
+			content:     []byte(testOPML),
+			wantFormat:  podcast.InputOPML,
+			wantIDs:     []uint64{101, 103},
+			wantSkipped: 1,
+		},
+		{
+			title:      "Gzip compressed CSV",
+			name:       "export.csv.gz",
+			content:    gzipped(t, "itunes_id\n101\n102\n"),
+			wantFormat: podcast.InputCSV,
+			wantIDs:    []uint64{101, 102},
+		},
+		{
+			title:      "Zstd compressed JSON Lines detected by content",
+			name:       "dump",
+			content:    zstdCompressed(t, "{\"id\": 101}\n{\"id\": 102}\n"),
+			wantFormat: podcast.InputJSONL,
+			wantIDs:    []uint64{101, 102},
+		},
+		{
+			title:       "OPML detected by content",
+			name:        "subscriptions",
+			content:     []byte("\xef\xbb\xbf" + testOPML),
+			wantFormat:  podcast.InputOPML,
+			wantIDs:     []uint64{101, 103},
+			wantSkipped: 1,
+		},
+		{
+			title:       "Format given explicitly",
+			name:        "ids.dat",
+			content:     []byte("101,102\n103\n"),
+			options:     podcast.InputOptions{Format: podcast.InputText},
+			wantFormat:  podcast.InputText,
+			wantIDs:     []uint64{103},
+			wantSkipped: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			ids, source := readInput(t, test.name, test.content, test.options)
+
+			if source.Format() != test.wantFormat {
+				t.Errorf("Format() = %s, want %s", source.Format(), test.wantFormat)
+			}
+			if len(ids) != len(test.wantIDs) {
+				t.Fatalf("read %v, want %v", ids, test.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != test.wantIDs[i] {
+					t.Fatalf("read %v, want %v", ids, test.wantIDs)
+				}
+			}
+			if source.Skipped() != test.wantSkipped {
+				t.Errorf("Skipped() = %d, want %d", source.Skipped(), test.wantSkipped)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		title   string
+		name    string
+		content string
+		options podcast.InputOptions
+	}{
+		{
+			title:   "Unknown format",
+			name:    "podcasts.txt",
+			content: "101\n",
+			options: podcast.InputOptions{Format: "yaml"},
+		},
+		{
+			title:   "Missing CSV column",
+			name:    "export.csv",
+			content: "title,itunes_id\nFirst,101\n",
+			options: podcast.InputOptions{IDField: "collection"},
+		},
+		{
+			title:   "CSV without an ID column",
+			name:    "export.csv",
+			content: "title,artist\nFirst,Someone\n",
+		},
+	}
+
+	for _, test := range errorTests {
+		t.Run(test.title, func(t *testing.T) {
+			test.options.Path = filepath.Join(t.TempDir(), test.name)
+			if err := os.WriteFile(test.options.Path, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := podcast.OpenInput(test.options); err == nil {
+				t.Error("OpenInput() succeeded, want an error")
+			}
+		})
+	}
+
+	t.Run("Reports malformed JSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dump.jsonl")
+		if err := os.WriteFile(path, []byte("{\"id\": 101}\n{\"id\": \n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		source, err := podcast.OpenInput(podcast.InputOptions{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer source.Close()
+
+		if _, err := source.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := source.Next(); err == nil || errors.Is(err, io.EOF) {
+			t.Errorf("Next() = %v, want a decoding error", err)
+		}
+	})
+}
@@ -39,6 +39,19 @@ type ItunesResult struct {
 	ArtworkUrl600          string   `json:"artworkUrl600"`
 	GenreIds               []string `json:"genreIds"`
 	Genres                 []string `json:"genres"`
+
+	Raw json.RawMessage `json:"-"` // The result as it was received
+}
+
+// Keeps the original JSON of the result alongside the parsed fields
+func (r *ItunesResult) UnmarshalJSON(data []byte) error {
+	type itunesResult ItunesResult // Drops this method to avoid recursing
+	if err := json.Unmarshal(data, (*itunesResult)(r)); err != nil {
+		return err
+	}
+
+	r.Raw = append(json.RawMessage(nil), data...)
+	return nil
 }
 
 type ItunesLookupResponse struct {
@@ -0,0 +1,51 @@
+package podcast_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/podcast"
+)
+
+func TestParseLookupResponse(t *testing.T) {
+	t.Run("Keeps the raw JSON of every result", func(t *testing.T) {
+		first := `{"collectionId":1,"collectionName":"First","collectionPrice":0.99,"kind":"podcast"}`
+		second := `{"collectionId":2,"collectionName":"Second","genreIds":["1310","26"]}`
+		response := `{"resultCount":2,"results":[` + first + `,` + second + `]}`
+
+		parsed, err := podcast.ParseLookupResponse(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed.Results) != 2 {
+			t.Fatalf("parsed %d results, want 2", len(parsed.Results))
+		}
+
+		for i, want := range []string{first, second} {
+			result := parsed.Results[i]
+			if string(result.Raw) != want {
+				t.Errorf("result %d raw = %s, want %s", i, result.Raw, want)
+			}
+			if result.CollectionId != uint32(i+1) {
+				t.Errorf("result %d collection ID = %d, want %d", i, result.CollectionId, i+1)
+			}
+		}
+	})
+
+	t.Run("Raw JSON holds fields that aren't parsed", func(t *testing.T) {
+		response := `{"resultCount":1,"results":[{"collectionId":1,"unmodelled":{"nested":true}}]}`
+
+		parsed, err := podcast.ParseLookupResponse(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(parsed.Results[0].Raw, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fields["unmodelled"]; !ok {
+			t.Errorf("raw result %s is missing the unmodelled field", parsed.Results[0].Raw)
+		}
+	})
+}
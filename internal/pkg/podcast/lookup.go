@@ -0,0 +1,42 @@
+package podcast
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+)
+
+// Looks ids up in a single request, outside of any fetcher. A nil client
+// uses http.DefaultClient
+func Lookup(ctx context.Context, client *http.Client, userAgent string, ids ...uint64) (*ItunesLookupResponse, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, PODCAST_LOOKUP_URL_BASE+utils.JoinNumbers(ids, ","), nil)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lookup failed with status %d", resp.StatusCode)
+	}
+
+	return ParseLookupResponse(string(body))
+}
@@ -0,0 +1,29 @@
+package podcast
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+)
+
+// Appends ids to filename, one per line. Creates the file and its parent
+// directories if they don't exist
+func WriteIDs(filename string, ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(utils.JoinNumbers(ids, "\n") + "\n")
+	return err
+}
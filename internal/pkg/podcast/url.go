@@ -1,55 +1,58 @@
 package podcast
 
 import (
-	"errors"
 	"fmt"
 	"math"
+	"net/url"
 	"strconv"
 	"strings"
 
-	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
 )
 
 const PODCAST_LOOKUP_URL_BASE = "https://itunes.apple.com/lookup?entity=podcast&id="
 
+// Reads the ID from the last path segment of an Apple Podcasts url, like
+// https://podcasts.apple.com/us/podcast/name/id1234. Urls of other hosts,
+// like feed urls, are refused even when their path looks the same
 func parseUrl(podcastUrl string) (uint64, error) {
-	if !utils.StringIncludes(podcastUrl, "/") {
-		return 0, errors.New(
-			fmt.Sprintf("invalid podcast url: %s", podcastUrl),
-		)
+	if !strings.Contains(podcastUrl, "://") {
+		podcastUrl = "https://" + podcastUrl
 	}
 
-	parts := strings.Split(podcastUrl, "/id")
-
-	id, err := strconv.ParseUint(parts[len(parts)-1], 0, 0)
+	u, err := url.Parse(podcastUrl)
 	if err != nil {
 		return 0, err
 	}
 
-	return id, nil
+	host := strings.ToLower(u.Hostname())
+	if host != "apple.com" && !strings.HasSuffix(host, ".apple.com") {
+		return 0, fmt.Errorf("%s is not an Apple Podcasts url", podcastUrl)
+	}
+
+	segments := strings.Split(strings.TrimRight(u.Path, "/"), "/")
+	id, ok := strings.CutPrefix(segments[len(segments)-1], "id")
+	if !ok {
+		return 0, fmt.Errorf("%s doesn't end with a podcast ID", podcastUrl)
+	}
+
+	return strconv.ParseUint(id, 10, 64)
 }
 
-// Extracts ids given a list of podcast urls
-func extractIDs(urls []string) []uint64 {
-	length := len(urls)
+// Parses an iTunes ID given on its own or as part of an Apple Podcasts url,
+// like https://podcasts.apple.com/us/podcast/name/id1234?i=5678
+func ParseID(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return id, nil
+	}
 
-	ids := make([]uint64, 0, length)
-	for _, value := range urls {
-		id, err := parseUrl(value)
-		if id == 0 {
-			logger.Warn.Println("Failed to parse a url")
-			continue
-		}
-		if err != nil {
-			logger.Warn.Print(err)
-			continue
-		}
-		ids = append(ids, id)
+	id, err := parseUrl(value)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("`%s` is neither an iTunes ID nor a podcast url", value)
 	}
 
-	logger.Info.Printf("Extracted %d IDs from %d URLs\n", len(ids), length)
-	return ids
+	return id, nil
 }
 
 func CreateBatchLookupUrls(baseUrl string, podcastIds []uint64, idsPerUrl int) []string {
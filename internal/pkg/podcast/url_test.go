@@ -56,3 +56,80 @@ func TestExtractLookupIDs(t *testing.T) {
 		})
 	}
 }
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		title   string
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{
+			title: "Plain ID",
+			input: " 1234 ",
+			want:  1234,
+		},
+		{
+			title: "Podcast url",
+			input: "https://podcasts.apple.com/us/podcast/some-show/id1234",
+			want:  1234,
+		},
+		{
+			title: "Podcast url with a query and a trailing slash",
+			input: "https://podcasts.apple.com/us/podcast/some-show/id1234/?i=5678#top",
+			want:  1234,
+		},
+		{
+			title: "Url without a scheme",
+			input: "itunes.apple.com/podcast/id1234",
+			want:  1234,
+		},
+		{
+			title: "IDs are decimal even with a leading zero",
+			input: "https://podcasts.apple.com/us/podcast/some-show/id01234",
+			want:  1234,
+		},
+		{
+			title:   "Hexadecimal ID",
+			input:   "https://podcasts.apple.com/us/podcast/some-show/id0x1f",
+			wantErr: true,
+		},
+		{
+			title:   "Feed url with an ID like path",
+			input:   "https://feeds.example.com/shows/id1234",
+			wantErr: true,
+		},
+		{
+			title:   "Host only ending in apple.com",
+			input:   "https://notapple.com/us/podcast/some-show/id1234",
+			wantErr: true,
+		},
+		{
+			title:   "ID not at the end of the path",
+			input:   "https://podcasts.apple.com/us/podcast/id1234/episodes",
+			wantErr: true,
+		},
+		{
+			title:   "Url without an ID",
+			input:   "https://podcasts.apple.com/us/podcast/some-show",
+			wantErr: true,
+		},
+		{
+			title:   "Neither an ID nor a url",
+			input:   "some-show",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			got, err := podcast.ParseID(test.input)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseID(%q) error = %v, wantErr %v", test.input, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseID(%q) = %d, want %d", test.input, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paces outgoing requests. Implementations must be safe for concurrent use
+type Limiter interface {
+	// Blocks until a request may be made or ctx is done
+	Wait(ctx context.Context) error
+	// Reports a throttled response. retryAfter is the wait the server asked
+	// for, or 0 if it didn't ask for one
+	Throttle(retryAfter time.Duration)
+	// Reports a successful response
+	Succeed()
+	// Returns the current request rate in requests per minute
+	Rate() float64
+}
+
+// Parses a Retry-After header given either as delay seconds or an HTTP date.
+// Returns 0 when the header is missing or invalid
+func ParseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+
+	return date.Sub(now)
+}
@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Rate reduction applied once per throttle window
+const throttleFactor = 0.5
+
+// Successful responses needed to recover from one throttle
+const recoverySteps = 20
+
+// Token bucket limiter. Tokens refill at a rate of requests per minute up to
+// burst. A throttled response pauses every request for the server's
+// Retry-After, or the cooldown when none was given, and halves the rate.
+// Responses throttled while a pause is on only extend it, so a burst of
+// requests throttled together cuts the rate once. Every successful response
+// then adds back a fraction of the configured rate until it is restored
+type TokenBucket struct {
+	mutex sync.Mutex
+
+	baseRate    float64 // Configured tokens per second
+	minRate     float64
+	rate        float64 // Current tokens per second
+	burst       float64
+	cooldown    time.Duration
+	tokens      float64
+	lastRefill  time.Time
+	pausedUntil time.Time
+
+	// Returns the current time. Defaults to time.Now
+	Clock func() time.Time
+}
+
+func NewTokenBucket(requestsPerMinute int, burst int, cooldown time.Duration) *TokenBucket {
+	rate := float64(requestsPerMinute) / 60
+
+	return &TokenBucket{
+		baseRate: rate,
+		minRate:  rate / 16,
+		rate:     rate,
+		burst:    float64(burst),
+		cooldown: cooldown,
+		tokens:   float64(burst),
+	}
+}
+
+func (b *TokenBucket) now() time.Time {
+	if b.Clock != nil {
+		return b.Clock()
+	}
+	return time.Now()
+}
+
+// Takes a token if one is available. Otherwise returns how long to wait
+// before trying again. Callers must hold the mutex
+func (b *TokenBucket) reserve(now time.Time) time.Duration {
+	if now.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(now)
+	}
+
+	if !b.lastRefill.IsZero() {
+		b.tokens += now.Sub(b.lastRefill).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.lastRefill = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Takes a token if one is available and returns 0. Otherwise returns how
+// long to wait before trying again
+func (b *TokenBucket) Reserve() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.reserve(b.now())
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait := b.Reserve()
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (b *TokenBucket) Throttle(retryAfter time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if retryAfter <= 0 {
+		retryAfter = b.cooldown
+	}
+
+	now := b.now()
+	paused := now.Before(b.pausedUntil)
+	if until := now.Add(retryAfter); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+
+	if !paused {
+		b.rate *= throttleFactor
+		if b.rate < b.minRate {
+			b.rate = b.minRate
+		}
+	}
+	b.tokens = 0
+	b.lastRefill = b.pausedUntil
+}
+
+func (b *TokenBucket) Succeed() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.rate >= b.baseRate {
+		return
+	}
+
+	b.rate += b.baseRate / recoverySteps
+	if b.rate > b.baseRate {
+		b.rate = b.baseRate
+	}
+}
+
+func (b *TokenBucket) Rate() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.rate * 60
+}
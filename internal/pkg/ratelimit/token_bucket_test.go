@@ -0,0 +1,164 @@
+package ratelimit_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/ratelimit"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestBucket(requestsPerMinute int, burst int) (*ratelimit.TokenBucket, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2023, time.August, 1, 0, 0, 0, 0, time.UTC)}
+	bucket := ratelimit.NewTokenBucket(requestsPerMinute, burst, time.Minute)
+	bucket.Clock = clock.Now
+
+	return bucket, clock
+}
+
+func TestTokenBucket(t *testing.T) {
+	t.Run("Allows a burst then paces requests at the configured rate", func(t *testing.T) {
+		bucket, clock := newTestBucket(20, 2)
+
+		for i := 0; i < 2; i++ {
+			if wait := bucket.Reserve(); wait != 0 {
+				t.Fatalf("Expected request %d of the burst to be allowed, but got a wait of %v", i+1, wait)
+			}
+		}
+
+		wait := bucket.Reserve()
+		if wait != 3*time.Second {
+			t.Fatalf("Expected a wait of 3s after the burst, but got %v", wait)
+		}
+
+		clock.Advance(wait)
+		if wait := bucket.Reserve(); wait != 0 {
+			t.Fatalf("Expected a request to be allowed after waiting, but got a wait of %v", wait)
+		}
+	})
+
+	t.Run("Pauses for Retry-After and slows down when throttled", func(t *testing.T) {
+		bucket, clock := newTestBucket(20, 1)
+
+		bucket.Throttle(10 * time.Second)
+		if rate := bucket.Rate(); rate != 10 {
+			t.Fatalf("Expected rate to halve to 10 requests per minute, but got %v", rate)
+		}
+
+		if wait := bucket.Reserve(); wait != 10*time.Second {
+			t.Fatalf("Expected a wait of 10s while throttled, but got %v", wait)
+		}
+
+		clock.Advance(10 * time.Second)
+		if wait := bucket.Reserve(); wait != 6*time.Second {
+			t.Fatalf("Expected a wait of 6s at the reduced rate, but got %v", wait)
+		}
+	})
+
+	t.Run("Slows down once for responses throttled together", func(t *testing.T) {
+		bucket, clock := newTestBucket(20, 1)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bucket.Throttle(10 * time.Second)
+			}()
+		}
+		wg.Wait()
+
+		if rate := bucket.Rate(); rate != 10 {
+			t.Fatalf("Expected concurrent throttles to halve the rate once to 10 requests per minute, but got %v", rate)
+		}
+
+		clock.Advance(10 * time.Second)
+		bucket.Throttle(10 * time.Second)
+		if rate := bucket.Rate(); rate != 5 {
+			t.Fatalf("Expected a throttle after the pause to halve the rate again to 5, but got %v", rate)
+		}
+	})
+
+	t.Run("Uses the cooldown when no Retry-After is given", func(t *testing.T) {
+		bucket, _ := newTestBucket(20, 1)
+
+		bucket.Throttle(0)
+		if wait := bucket.Reserve(); wait != time.Minute {
+			t.Fatalf("Expected a wait of 1m while throttled, but got %v", wait)
+		}
+	})
+
+	t.Run("Recovers the configured rate gradually", func(t *testing.T) {
+		bucket, _ := newTestBucket(20, 1)
+
+		bucket.Throttle(0)
+		bucket.Succeed()
+		if rate := bucket.Rate(); rate <= 10 || rate >= 20 {
+			t.Fatalf("Expected a partially recovered rate, but got %v", rate)
+		}
+
+		for i := 0; i < 100; i++ {
+			bucket.Succeed()
+		}
+		if rate := bucket.Rate(); rate != 20 {
+			t.Fatalf("Expected the rate to recover to 20 requests per minute, but got %v", rate)
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, time.August, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		title  string
+		header string
+		want   time.Duration
+	}{
+		{
+			title:  "Delay seconds",
+			header: "120",
+			want:   2 * time.Minute,
+		},
+		{
+			title:  "HTTP date",
+			header: "Tue, 01 Aug 2023 00:00:30 GMT",
+			want:   30 * time.Second,
+		},
+		{
+			title:  "HTTP date in the past",
+			header: "Mon, 31 Jul 2023 00:00:00 GMT",
+			want:   0,
+		},
+		{
+			title:  "Missing header",
+			header: "",
+			want:   0,
+		},
+		{
+			title:  "Invalid header",
+			header: "soon",
+			want:   0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			result := ratelimit.ParseRetryAfter(test.header, now)
+			if result != test.want {
+				t.Errorf("Input: %q, got: %v, want: %v", test.header, result, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package structures
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// A pool whose items only become available once their ready time has passed
+type DelayPool[T any] interface {
+	Put(time.Time, ...T)
+	TakeReady(time.Time) []T
+	Length() int
+}
+
+type delayedItems[T any] struct {
+	readyAt time.Time
+	items   []T
+}
+
+// Min-heap of delayed items ordered by ready time
+type delayHeap[T any] []delayedItems[T]
+
+func (h delayHeap[T]) Len() int           { return len(h) }
+func (h delayHeap[T]) Less(i, j int) bool { return h[i].readyAt.Before(h[j].readyAt) }
+func (h delayHeap[T]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap[T]) Push(x any) {
+	*h = append(*h, x.(delayedItems[T]))
+}
+
+func (h *delayHeap[T]) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+type delayPool[T any] struct {
+	entries delayHeap[T]
+	length  int
+	mutex   *sync.Mutex
+}
+
+func (p *delayPool[T]) Put(readyAt time.Time, items ...T) {
+	if len(items) == 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	heap.Push(&p.entries, delayedItems[T]{readyAt: readyAt, items: items})
+	p.length += len(items)
+	p.mutex.Unlock()
+}
+
+// Removes and returns every item whose ready time is at or before now
+func (p *delayPool[T]) TakeReady(now time.Time) []T {
+	p.mutex.Lock()
+
+	ready := []T{}
+	for len(p.entries) > 0 && !p.entries[0].readyAt.After(now) {
+		entry := heap.Pop(&p.entries).(delayedItems[T])
+		ready = append(ready, entry.items...)
+	}
+	p.length -= len(ready)
+
+	p.mutex.Unlock()
+
+	return ready
+}
+
+func (p *delayPool[T]) Length() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.length
+}
+
+func CreateDelayPool[T any]() DelayPool[T] {
+	return &delayPool[T]{
+		entries: delayHeap[T]{},
+		mutex:   &sync.Mutex{},
+	}
+}
@@ -0,0 +1,55 @@
+package structures_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/structures"
+)
+
+func TestDelayPool(t *testing.T) {
+	now := time.Now()
+	pool := structures.CreateDelayPool[uint64]()
+	pool.Put(now.Add(time.Minute), 3, 4)
+	pool.Put(now.Add(-time.Second), 1)
+	pool.Put(now, 2)
+
+	t.Run("Get pool length", func(t *testing.T) {
+		expectedLength := 4
+		actualLength := pool.Length()
+		if actualLength != expectedLength {
+			t.Errorf("Expected pool length %d, but got %d", expectedLength, actualLength)
+		}
+	})
+
+	t.Run("Take ready items", func(t *testing.T) {
+		items := pool.TakeReady(now)
+		sort.Slice(items, func(i, j int) bool { return items[i] < items[j] })
+
+		expectedItems := []uint64{1, 2}
+		if !reflect.DeepEqual(items, expectedItems) {
+			t.Errorf("Expected ready items %v, but got %v", expectedItems, items)
+		}
+
+		expectedLength := 2
+		actualLength := pool.Length()
+		if actualLength != expectedLength {
+			t.Errorf("Expected pool length %d after taking ready items, but got %d", expectedLength, actualLength)
+		}
+	})
+
+	t.Run("Items are not taken before they are ready", func(t *testing.T) {
+		items := pool.TakeReady(now.Add(30 * time.Second))
+		if len(items) != 0 {
+			t.Errorf("Expected no ready items, but got %v", items)
+		}
+
+		items = pool.TakeReady(now.Add(time.Minute))
+		expectedItems := []uint64{3, 4}
+		if !reflect.DeepEqual(items, expectedItems) {
+			t.Errorf("Expected ready items %v, but got %v", expectedItems, items)
+		}
+	})
+}
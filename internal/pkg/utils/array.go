@@ -55,6 +55,19 @@ func LeftDiff[T comparable](a []T, b []T) []T {
 	return uniqueToA
 }
 
+// Splits arr into consecutive slices of at most size elements
+func Chunk[T any](arr []T, size int) [][]T {
+	chunks := make([][]T, 0, (len(arr)+size-1)/size)
+	for size < len(arr) {
+		arr, chunks = arr[size:], append(chunks, arr[:size])
+	}
+	if len(arr) > 0 {
+		chunks = append(chunks, arr)
+	}
+
+	return chunks
+}
+
 func JoinNumbers[T Number](elems []T, sep string) string {
 	idsStr := ""
 
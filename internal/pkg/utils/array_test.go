@@ -1,6 +1,7 @@
 package utils_test
 
 import (
+	"reflect"
 	"sort"
 	"testing"
 
@@ -43,6 +44,25 @@ func TestLeftDiff(t *testing.T) {
 	})
 }
 
+func TestChunk(t *testing.T) {
+	t.Run("Splits slice into chunks of at most the given size", func(t *testing.T) {
+		input := []int{1, 2, 3, 4, 5, 6, 7}
+		expected := [][]int{{1, 2, 3}, {4, 5, 6}, {7}}
+
+		result := utils.Chunk(input, 3)
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("Chunk() returned an incorrect result.\nExpected: %v\nResult: %v\n", expected, result)
+		}
+	})
+
+	t.Run("Returns no chunks on empty slice", func(t *testing.T) {
+		result := utils.Chunk([]int{}, 3)
+		if len(result) != 0 {
+			t.Fatalf("Chunk() returned %d chunks for an empty slice\n", len(result))
+		}
+	})
+}
+
 func TestJoinNumbers(t *testing.T) {
 	t.Run("Returns joined ids on valid input", func(t *testing.T) {
 		validInput := []uint64{123, 234, 456}
@@ -1,26 +1,30 @@
 package utils
 
-import "time"
+import (
+	"math/rand"
+	"time"
+)
 
-// Naive incremental backoff implementation
-func IncrementalBackoff(fn func() error) error {
-	tresholds := []uint8{
-		5,  // 5 seconds
-		30, // 30 seconds
-		60, // 1 minute
+// Returns the wait before retry number attempt (starting at 1). The wait
+// doubles with every attempt up to max, and a random jitter of up to half the
+// wait keeps retries of items that failed together from lining up again
+func ExponentialBackoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
 	}
 
-	var err error
-
-	for _, waitTime := range tresholds {
-		duration := time.Second * time.Duration(waitTime)
-		time.Sleep(duration)
+	wait := base
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
 
-		err = fn()
-		if err == nil {
-			return nil
-		}
+	half := wait / 2
+	if half <= 0 {
+		return wait
 	}
 
-	return err
+	return half + time.Duration(rand.Int63n(int64(half)+1))
 }
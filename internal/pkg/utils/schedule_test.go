@@ -0,0 +1,50 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	base := 10 * time.Second
+	max := time.Minute
+
+	tests := []struct {
+		title   string
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{
+			title:   "First attempt waits between half the base and the base",
+			attempt: 1,
+			min:     5 * time.Second,
+			max:     10 * time.Second,
+		},
+		{
+			title:   "Wait doubles with every attempt",
+			attempt: 3,
+			min:     20 * time.Second,
+			max:     40 * time.Second,
+		},
+		{
+			title:   "Wait is capped at max",
+			attempt: 20,
+			min:     30 * time.Second,
+			max:     time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				wait := utils.ExponentialBackoff(test.attempt, base, max)
+				if wait < test.min || wait > test.max {
+					t.Fatalf("ExponentialBackoff(%d) returned %v, want between %v and %v", test.attempt, wait, test.min, test.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Returns a random (version 4) UUID in its canonical string form
+func NewUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("utils: unable to read random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,30 @@
+package utils_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/utils"
+)
+
+func TestNewUUID(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	t.Run("Is a canonical version 4 UUID", func(t *testing.T) {
+		id := utils.NewUUID()
+		if !pattern.MatchString(id) {
+			t.Errorf("NewUUID() = %s, want a version 4 UUID", id)
+		}
+	})
+
+	t.Run("Is different every time", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			id := utils.NewUUID()
+			if seen[id] {
+				t.Fatalf("NewUUID() returned %s twice", id)
+			}
+			seen[id] = true
+		}
+	})
+}
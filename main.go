@@ -1,54 +1,53 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/app"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/config"
-	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/database"
 	"github.com/bigusbeckus/podcast-feed-fetcher/internal/pkg/logger"
 )
 
-func Init() {
-	fmt.Println("Performing initialization tasks")
+// Loads the config and initializes the logger. Returns the command line
+// arguments left after config flags. Progress goes to stderr so commands
+// can keep stdout to themselves
+func Init() []string {
+	fmt.Fprintln(os.Stderr, "Performing initialization tasks")
 
 	// Load config
-	fmt.Print("Loading config file...")
-	err := config.Load()
+	fmt.Fprint(os.Stderr, "Loading config...")
+	args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		fmt.Printf("\n%v\n", err.Error())
+		fmt.Fprintf(os.Stderr, "\n%v\n", err.Error())
 		os.Exit(1)
 	}
-	fmt.Println("Done")
+	fmt.Fprintln(os.Stderr, "Done")
 
 	// Initialize logger
-	fmt.Print("Initializing logger...")
+	fmt.Fprint(os.Stderr, "Initializing logger...")
 	err = logger.Init()
 	if err != nil {
-		fmt.Printf("\n%v\n", err.Error())
+		fmt.Fprintf(os.Stderr, "\n%v\n", err.Error())
 		os.Exit(1)
 	}
-	fmt.Println("Done")
-
-	fmt.Println("Initialization complete")
-}
+	fmt.Fprintln(os.Stderr, "Done")
 
-func SetupDB() {
-	// Run database migrations
-	logger.Info.Println("Database migrations started")
-	err := database.RunMigrations()
-	if err != nil {
-		logger.Error.Fatalf("\n%v\n", err.Error())
-	}
-	logger.Info.Println("Database migrations successful")
+	fmt.Fprintln(os.Stderr, "Initialization complete")
+	return args
 }
 
 func main() {
-	Init()
-	logger.PrintHeading("Podcast Feed Fetcher")
+	args := Init()
 
-	SetupDB()
+	if len(args) == 0 {
+		args = []string{"crawl"}
+	}
 
-	app.Start(config.AppConfig.SaveTreshold)
+	os.Exit(app.RunCommand(args[0], args[1:]))
 }